@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -9,7 +10,9 @@ import (
 )
 
 func main() {
-	s := srv.New()
+	s := srv.NewWithConfig(srv.Config{
+		Logger: srv.NewSlogLogger(slog.Default()),
+	})
 
 	handler := func(c *srv.Context) {
 		c.SendDirect(200, []byte("hello world!"))
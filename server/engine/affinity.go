@@ -0,0 +1,92 @@
+// worker cpu pinning: LockOSThread + sched_setaffinity
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cpu mask in kernel format, 1024 cpus like glibc cpu_set_t
+type cpuSet [16]uint64
+
+// cpus allowed for our process (respects taskset and cgroups cpuset)
+func allowedCPUs() ([]int, error) {
+	var set cpuSet
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &set); err != nil {
+		return nil, err
+	}
+
+	cpus := make([]int, 0, runtime.NumCPU())
+	for i := range len(set) * 64 {
+		if set[i/64]&(1<<(i%64)) != 0 {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+// lock current goroutine to its OS thread and bind this thread to cpu;
+// returned func gives thread back to scheduler with its original cpu mask,
+// on error thread is already unlocked
+func pinThread(cpu int) (func(), error) {
+	if cpu < 0 || cpu >= len(cpuSet{})*64 {
+		return nil, fmt.Errorf("cpu %d out of range", cpu)
+	}
+	runtime.LockOSThread()
+
+	// pid 0 means calling thread
+	var orig cpuSet
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &orig); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	var set cpuSet
+	set[cpu/64] |= 1 << (cpu % 64)
+	if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &set); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+
+	return func() {
+		schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &orig)
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+// get or set cpu mask of calling thread
+func schedAffinity(trap uintptr, set *cpuSet) error {
+	_, _, errno := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*set), uintptr(unsafe.Pointer(set)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// build worker -> cpu mapping, accept loop goes to first cpu of the set;
+// returns cpu for accept loop and for every worker
+func (e *Engine) cpuMap() (int, []int, error) {
+	cpus := e.CPUs
+	if len(cpus) == 0 {
+		var err error
+		if cpus, err = allowedCPUs(); err != nil {
+			return -1, nil, err
+		}
+	}
+
+	workers := make([]int, len(cpus))
+	copy(workers, cpus)
+	return cpus[0], workers, nil
+}
+
+// report mapping at startup
+func (e *Engine) reportCPUMap(acceptCPU int, workers []int) {
+	if !e.enabled(LevelInfo) {
+		return
+	}
+	e.Log.Log(LevelInfo, "accept loop pinned", Int("cpu", acceptCPU))
+	for i, cpu := range workers {
+		e.Log.Log(LevelInfo, "worker pinned", Int("worker", i), Int("cpu", cpu))
+	}
+}
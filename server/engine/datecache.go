@@ -5,29 +5,48 @@ import (
 	"time"
 )
 
-// i use global variable bc it is unefficient to store struct in session,
-// i would use date only in response builder
-var CurrentDate atomic.Pointer[[]byte]
-
+// cached Date header value, every Engine owns one,
+// so several servers in one process don't share buffers
+//
 // подход с двумя буферами и атомарной заменой указателя помогает избежать
 // гонок данных (а также сохранить 0 аллокаций), потому что если бы я использовал 1 буфер, то адрес в памяти бы не менялся,
 // а значит при изменении и чтении в 1 момент могла произойти гонка данных
-
-var (
+type DateCache struct {
+	cur        atomic.Pointer[[]byte]
 	buf1, buf2 [29]byte
-	datetick   bool
-)
+	tick       bool
+}
 
-func (e *Engine) UpdateDate() {
+// format current time to spare buffer and swap pointer,
+// should be called from one goroutine only (engine loop)
+func (d *DateCache) Update() {
 	var b []byte
-	if datetick {
-		b = buf1[:0]
+	if d.tick {
+		b = d.buf1[:0]
 	} else {
-		b = buf2[:0]
+		b = d.buf2[:0]
 	}
 
 	res := time.Now().UTC().AppendFormat(b, time.RFC1123)
 
-	CurrentDate.Store(&res)
-	datetick = !datetick
+	d.cur.Store(&res)
+	d.tick = !d.tick
+}
+
+// current date, nil if cache was never updated
+func (d *DateCache) Load() []byte {
+	p := d.cur.Load()
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func (e *Engine) UpdateDate() {
+	e.date.Update()
+}
+
+// cached Date header value of this engine
+func (e *Engine) Date() []byte {
+	return e.date.Load()
 }
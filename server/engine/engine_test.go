@@ -1,13 +1,20 @@
 package engine
 
 import (
+	"io"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 )
 
+var discard = io.Discard
+
 func mockParse(s *Session) (bool, error) {
 	s.Offset = 0
 	s.Req = RawRequest{}
@@ -18,17 +25,17 @@ func mockParse(s *Session) (bool, error) {
 
 func BenchmarkEpollServer(b *testing.B) {
 	addr := [4]byte{127, 0, 0, 1}
-	port := 8888
-	target := "127.0.0.1:8888"
 	e := Engine{}
 
 	go func() {
-		if err := e.StartEpoll(addr, port, mockParse); err != nil {
+		if err := e.StartEpoll(addr, 0, mockParse); err != nil {
 			return
 		}
 	}()
+	defer e.StopServer(&discard)
 
-	time.Sleep(500 * time.Millisecond)
+	<-e.Ready()
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(e.Port()))
 
 	// Подготавливаем статический запрос
 	req := []byte("GET /h HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n")
@@ -76,7 +83,9 @@ func BenchmarkWriteBuf(b *testing.B) {
 	}
 	defer devNull.Close()
 
-	s := &Session{Fd: uint32(devNull.Fd())}
+	e := &Engine{}
+	e.init()
+	s := &Session{Fd: uint32(devNull.Fd()), eng: e}
 
 	b.ResetTimer()
 	b.ReportAllocs()
@@ -88,3 +97,346 @@ func BenchmarkWriteBuf(b *testing.B) {
 		}
 	}
 }
+
+func TestPinThread(t *testing.T) {
+	cpus, err := allowedCPUs()
+	if err != nil || len(cpus) == 0 {
+		t.Fatalf("allowedCPUs: %v %v", cpus, err)
+	}
+	target := cpus[len(cpus)-1]
+
+	res := make(chan [2][]int)
+	go func() {
+		unpin, err := pinThread(target)
+		if err != nil {
+			t.Error(err)
+			res <- [2][]int{}
+			return
+		}
+		pinned, _ := allowedCPUs()
+		unpin()
+		restored, _ := allowedCPUs()
+		res <- [2][]int{pinned, restored}
+	}()
+
+	got := <-res
+	if len(got[0]) != 1 || got[0][0] != target {
+		t.Errorf("expected affinity [%d], got %v", target, got[0])
+	}
+	if !slices.Equal(got[1], cpus) {
+		t.Errorf("expected mask %v restored, got %v", cpus, got[1])
+	}
+}
+
+func TestStartEpollUnpinsCaller(t *testing.T) {
+	cpus, err := allowedCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{PinCPU: true, CPUs: cpus[:1]}
+
+	res := make(chan []int)
+	go func() {
+		e.StartEpoll([4]byte{127, 0, 0, 1}, 0, mockParse)
+		after, _ := allowedCPUs()
+		res <- after
+	}()
+	<-e.Ready()
+	// no logger: progress goes to writer as plain lines
+	var out strings.Builder
+	w := io.Writer(&out)
+	e.StopServer(&w)
+
+	if after := <-res; !slices.Equal(after, cpus) {
+		t.Errorf("caller thread left pinned: %v, expected %v", after, cpus)
+	}
+	if !strings.HasSuffix(out.String(), "server is down sessions_closed=0\n") {
+		t.Errorf("unexpected stop progress %q", out.String())
+	}
+}
+
+func TestStopBeforeLoopStopsWorkers(t *testing.T) {
+	e := &Engine{}
+	// stop comes first, engine is not started and StopServer doesn't wait for it
+	e.StopServer(&discard)
+
+	res := make(chan error)
+	go func() { res <- e.StartEpoll([4]byte{127, 0, 0, 1}, 0, mockParse) }()
+	select {
+	case err := <-res:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event loop didn't exit")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		e.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers are left running")
+	}
+}
+
+func TestListenerOptions(t *testing.T) {
+	opts := ListenerOptions{
+		ReuseAddr:    true,
+		NoDelay:      true,
+		DeferAccept:  2 * time.Second,
+		KeepAlive:    true,
+		KeepIdle:     30 * time.Second,
+		KeepInterval: 5 * time.Second,
+		KeepCount:    4,
+		RcvBuf:       1 << 16,
+	}
+
+	lfd, err := listenSocket([4]byte{127, 0, 0, 1}, 0, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(lfd)
+
+	getopt := func(fd, level, opt int) int {
+		v, err := syscall.GetsockoptInt(fd, level, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if getopt(lfd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR) == 0 {
+		t.Error("SO_REUSEADDR is not set")
+	}
+	if getopt(lfd, syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT) == 0 {
+		t.Error("TCP_DEFER_ACCEPT is not set")
+	}
+	// kernel doubles requested buffer size
+	if v := getopt(lfd, syscall.SOL_SOCKET, syscall.SO_RCVBUF); v < opts.RcvBuf {
+		t.Errorf("SO_RCVBUF: expected >= %d, got %d", opts.RcvBuf, v)
+	}
+
+	sa, _ := syscall.Getsockname(lfd)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(sa.(*syscall.SockaddrInet4).Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// defer accept holds conn until data arrives
+	conn.Write([]byte("x"))
+
+	nfd, _, err := syscall.Accept(lfd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(nfd)
+
+	if err := opts.applyConn(nfd); err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name       string
+		level, opt int
+		want       int
+	}{
+		{"TCP_NODELAY", syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1},
+		{"SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 30},
+		{"TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, 5},
+		{"TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 4},
+	}
+	for _, c := range checks {
+		if v := getopt(nfd, c.level, c.opt); v != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, v)
+		}
+	}
+}
+
+func BenchmarkWriteV(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	e := &Engine{}
+	e.init()
+	s := &Session{Fd: uint32(devNull.Fd()), eng: e}
+	body := make([]byte, 1<<20)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for b.Loop() {
+		_, err := WriteV(s, mockPayload, body)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 16 pipelined responses: one write per response vs one coalesced write per batch
+func BenchmarkPipelinedWrites(b *testing.B) {
+	const pipelined = 16
+
+	for _, mode := range []string{"direct", "coalesced"} {
+		b.Run(mode, func(b *testing.B) {
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer syscall.Close(fds[0])
+
+			// drain peer so writes never block
+			go func() {
+				buf := make([]byte, 1<<16)
+				for {
+					if n, _ := syscall.Read(fds[1], buf); n <= 0 {
+						return
+					}
+				}
+			}()
+			defer syscall.Close(fds[1])
+
+			e := &Engine{}
+			e.init()
+			s := &Session{Fd: uint32(fds[0]), eng: e}
+			body := []byte("Hello, world!")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for b.Loop() {
+				s.batching = mode == "coalesced"
+				for range pipelined {
+					if _, err := WriteV(s, mockPayload, body); err != nil {
+						b.Fatal(err)
+					}
+				}
+				s.batching = false
+				if err := s.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(e.WriteCalls())/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestSessionBatching(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	e := &Engine{}
+	e.init()
+	s := &Session{Fd: uint32(fds[0]), eng: e}
+
+	s.batching = true
+	for _, body := range []string{"first", "second", "third"} {
+		WriteV(s, func(dst []byte) int { return copy(dst, "R:") }, []byte(body))
+	}
+	Write404(s)
+	s.batching = false
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := e.WriteCalls(); got != 1 {
+		t.Errorf("expected 1 write, got %d", got)
+	}
+
+	buf := make([]byte, 1024)
+	n, _ := syscall.Read(fds[1], buf)
+	want := "R:firstR:secondR:third" + string(res404)
+	if string(buf[:n]) != want {
+		t.Errorf("expected %q, got %q", want, buf[:n])
+	}
+}
+
+func TestWheelKeepsPersistent(t *testing.T) {
+	e := &Engine{}
+	e.init()
+	e.sessions = make([]atomic.Pointer[Session], 8)
+
+	s := &Session{Fd: 5, eng: e}
+	s.SetPersistent(true)
+	e.sessions[5].Store(s)
+
+	tw := NewWheel(1)
+	tw.Update(s)
+	for range 4 {
+		tw.killSharded(e)
+	}
+	if e.sessions[5].Load() != s {
+		t.Fatal("persistent session was evicted")
+	}
+}
+
+func TestSessionPendingOutput(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	syscall.SetNonblock(fds[0], true)
+
+	s := &Session{Fd: uint32(fds[0])}
+	body := make([]byte, 1<<20)
+	if n, err := s.WriteDirect(nil, body); n != len(body) || err != nil {
+		t.Fatalf("write: %d %v", n, err)
+	}
+	if s.Pending() == 0 {
+		t.Fatal("expected queued output, socket buffer is smaller than body")
+	}
+	ready := s.Writable()
+
+	// peer reads, worker sends queue as socket becomes writable
+	got := make(chan int)
+	go func() {
+		buf, total := make([]byte, 1<<16), 0
+		for total < len(body) {
+			n, err := syscall.Read(fds[1], buf)
+			if err != nil {
+				break
+			}
+			total += n
+		}
+		got <- total
+	}()
+	for {
+		pending, err := s.flushPending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := <-got; n != len(body) {
+		t.Errorf("peer got %d bytes", n)
+	}
+	select {
+	case <-ready:
+	default:
+		t.Error("Writable is not closed after drain")
+	}
+
+	// client that never reads
+	for range maxPending/len(body) + 1 {
+		if _, err = s.WriteDirect(nil, body); err != nil {
+			break
+		}
+	}
+	if err != ErrSlowPeer {
+		t.Errorf("expected ErrSlowPeer, got %v", err)
+	}
+}
@@ -3,7 +3,9 @@
 package engine
 
 import (
+	"net/netip"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -11,39 +13,117 @@ import (
 
 // engine struct for storing session state (mainly for graceful shutdown)
 type Engine struct {
+	// structured logger for engine events, nil means no logging (zero cost)
+	Log Logger
+
+	// socket options for listener and accepted conns, nil means DefaultListenerOptions
+	Listener *ListenerOptions
+
+	// pin every worker and accept loop to its own OS thread and cpu (opt-in)
+	PinCPU bool
+	// cpu set for pinning, one worker per cpu; empty means all cpus allowed for process
+	CPUs []int
+
 	lsfd, epollfd int
 	sessions      []atomic.Pointer[Session]
-	jobsarr       []chan int
+	workers       sync.WaitGroup
+
+	// per engine state: session buffers, sessions and Date header cache
+	bufPool     sync.Pool
+	sessionPool sync.Pool
+	date        DateCache
+	writes      atomic.Uint64
+
+	// lifecycle: ready is closed when engine accepts conns, done when event loop exits
+	once    sync.Once
+	ready   chan struct{}
+	done    chan struct{}
+	started atomic.Bool
+	closing atomic.Bool
 }
 
 const (
 	backlog   = 128 // backlog for listening
 	maxEvents = 256
+	waitMs    = 100 // epoll wait timeout, so loop can tick and notice shutdown without events
 )
 
+// lazy init for channels, so zero Engine is usable
+func (e *Engine) init() {
+	e.once.Do(func() {
+		e.ready = make(chan struct{})
+		e.done = make(chan struct{})
+		e.bufPool.New = newBuf
+		e.sessionPool.New = newSession
+	})
+}
+
+// closed when listener and workers are up, safe to call before StartEpoll
+func (e *Engine) Ready() <-chan struct{} {
+	e.init()
+	return e.ready
+}
+
+// bound port of listening socket (useful when started on port 0), valid after Ready
+func (e *Engine) Port() int {
+	sa, err := syscall.Getsockname(e.lsfd)
+	if err != nil {
+		return 0
+	}
+	if a, ok := sa.(*syscall.SockaddrInet4); ok {
+		return a.Port
+	}
+	return 0
+}
+
+// add already connected socket (socketpair end for example) to engine as a new client,
+// should be called after Ready; engine owns fd after that
+func (e *Engine) Attach(fd int) error {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return err
+	}
+	return syscall.EpollCtl(e.epollfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	})
+}
+
 // callback func for handling raw data from socket,
 // fd is socket descriptor, and s is Session related to this descriptor
-type handleConn func(s *Session) (bool, error)
+type HandleConn func(s *Session) (bool, error)
 
 // starting our server;
 // should be called from server.go;
 // arguments: address, port and handle conn func (do w socket)
-func (e *Engine) StartEpoll(addr [4]byte, port int, cb handleConn) error {
-	fd, err := listenSocket(addr, port)
+func (e *Engine) StartEpoll(addr [4]byte, port int, cb HandleConn) error {
+	e.init()
+	defer close(e.done)
+
+	opts := e.listenerOptions()
+	fd, err := listenSocket(addr, port, &opts)
 	if err != nil {
+		if e.enabled(LevelError) {
+			e.Log.Log(LevelError, "listen failed", Int("port", port), Err(err))
+		}
 		return err
 	}
 	defer syscall.Close(fd)
 	e.lsfd = fd
 
 	// creating new epoll instance
-	epollfd, _ := syscall.EpollCreate1(0)
+	epollfd, err := syscall.EpollCreate1(0)
+	if err != nil {
+		return err
+	}
 	e.epollfd = epollfd
+	defer syscall.Close(epollfd)
 	// register listening socket to epoll
-	syscall.EpollCtl(epollfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+	if err := syscall.EpollCtl(epollfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
 		Events: syscall.EPOLLIN,
 		Fd:     int32(fd),
-	})
+	}); err != nil {
+		return err
+	}
 
 	// get r limit (means max count of descriptors)
 	rlim := syscall.Rlimit{}
@@ -60,22 +140,66 @@ func (e *Engine) StartEpoll(addr [4]byte, port int, cb handleConn) error {
 	// но обеспечат максимальный перформанс
 
 	numworkers := runtime.NumCPU()
+
+	// cpu pinning: -1 means worker floats across threads as usual
+	var workercpu []int
+	if e.PinCPU {
+		acpu, wcpu, err := e.cpuMap()
+		if err != nil {
+			return err
+		}
+		// caller's thread is pinned while loop runs and is given back as it was
+		unpin, err := pinThread(acpu)
+		if err != nil {
+			return err
+		}
+		defer unpin()
+		workercpu = wcpu
+		numworkers = len(wcpu)
+		e.reportCPUMap(acpu, wcpu)
+	}
+
 	jobs := make([]chan int, numworkers)
 	for i := range numworkers {
+		cpu := -1
+		if workercpu != nil {
+			cpu = workercpu[i]
+		}
 		jobs[i] = make(chan int, 1<<10)
-		go workerEpoll(epollfd, jobs[i], Sessions, cb)
+		e.workers.Add(1)
+		go func() {
+			defer e.workers.Done()
+			e.workerEpoll(jobs[i], cb, cpu)
+		}()
 	}
-	e.jobsarr = jobs
+	// workers are stopped on loop exit, not by StopServer: stop can come before loop is started
+	// and then StopServer doesn't wait for it; done is closed after this, so StopServer sees them gone
+	defer func() {
+		for _, ch := range jobs {
+			close(ch)
+		}
+		e.workers.Wait()
+	}()
 	events := make([]syscall.EpollEvent, maxEvents)
 
 	e.UpdateDate()
 
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
 	// я создаю один глобальный тикер при инициализации еполла
 	// такой подход выбран чтобы привязать таймер к конкретному воркеру и конкретному потоку, не запуская отдельную горутину под него
 
-	for {
+	e.started.Store(true)
+	close(e.ready)
+
+	if e.enabled(LevelInfo) {
+		e.Log.Log(LevelInfo, "engine started",
+			Str("addr", netip.AddrFrom4(addr).String()), Int("port", e.Port()), Int("workers", numworkers))
+	}
+
+	// loop is the only sender to jobs, so channels are closed after it
+	for !e.closing.Load() {
 		select {
 		case <-ticker.C:
 			e.UpdateDate()
@@ -85,7 +209,7 @@ func (e *Engine) StartEpoll(addr [4]byte, port int, cb handleConn) error {
 
 		default:
 			// number of events to accept
-			n, err := syscall.EpollWait(epollfd, events, -1)
+			n, err := syscall.EpollWait(epollfd, events, waitMs)
 			if err != nil {
 				continue
 			}
@@ -94,41 +218,82 @@ func (e *Engine) StartEpoll(addr [4]byte, port int, cb handleConn) error {
 				efd := int(events[i].Fd) // current event descriptor
 
 				if efd == fd {
-					nfd, _, _ := syscall.Accept(fd) // new descriptor for new client
+					nfd, _, err := syscall.Accept(fd) // new descriptor for new client
+					if err != nil {
+						if err != syscall.EAGAIN && e.enabled(LevelWarn) {
+							e.Log.Log(LevelWarn, "accept failed", Err(err))
+						}
+						continue
+					}
 					syscall.SetNonblock(nfd, true)
+					if err := opts.applyConn(nfd); err != nil && e.enabled(LevelWarn) {
+						e.Log.Log(LevelWarn, "socket options failed", Int("fd", nfd), Err(err))
+					}
 
-					syscall.EpollCtl(epollfd, syscall.EPOLL_CTL_ADD, nfd, // adding new descriptor to epoll
+					if err := syscall.EpollCtl(epollfd, syscall.EPOLL_CTL_ADD, nfd, // adding new descriptor to epoll
 						&syscall.EpollEvent{
 							Events: syscall.EPOLLIN | syscall.EPOLLONESHOT,
 							Fd:     int32(nfd),
-						})
-					syscall.SetsockoptInt(nfd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+						}); err != nil {
+						if e.enabled(LevelError) {
+							e.Log.Log(LevelError, "epoll add failed", Int("fd", nfd), Err(err))
+						}
+						syscall.Close(nfd)
+						continue
+					}
 				} else {
 					jobs[efd%numworkers] <- efd
 				}
 			}
 		}
 	}
+	return nil
 }
 
-// create new socket, bind and start listening
-func listenSocket(addr [4]byte, port int) (int, error) {
+// create new socket, set options, bind and start listening
+func listenSocket(addr [4]byte, port int, opts *ListenerOptions) (int, error) {
 	// SOCK_STREAM = TCP
 	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
 	if err != nil {
 		return -1, err
 	}
 
+	if err := opts.applyBind(fd); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
 	if err := syscall.Bind(fd, &syscall.SockaddrInet4{ // bind socket to addr:port
 		Port: port,
 		Addr: addr,
 	}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := opts.applyListen(fd); err != nil {
+		syscall.Close(fd)
 		return -1, err
 	}
 	if err := syscall.Listen(fd, backlog); err != nil { // start listening on addr:port
+		syscall.Close(fd)
 		return -1, err
 	}
 
 	// log.Printf("new socket started on %d:%d, fd = %d", addr, port, fd)
 	return fd, nil
 }
+
+// get socket peer address, zero AddrPort if socket is not inet (socketpair for example)
+func peerAddr(fd int) netip.AddrPort {
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(a.Addr), uint16(a.Port))
+	case *syscall.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(a.Addr), uint16(a.Port))
+	}
+	return netip.AddrPort{}
+}
@@ -0,0 +1,77 @@
+// pluggable structured logger for engine and server events
+package engine
+
+import (
+	"context"
+	"log/slog"
+)
+
+// log level, values match log/slog levels
+type Level int8
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// kind of Field value
+const (
+	fieldStr uint8 = iota
+	fieldInt
+	fieldErr
+)
+
+// structured field, plain struct instead of any so building it doesn't box values
+type Field struct {
+	Key  string
+	Str  string
+	Int  int64
+	Err  error
+	kind uint8
+}
+
+func Str(key, val string) Field     { return Field{Key: key, Str: val, kind: fieldStr} }
+func Int(key string, val int) Field { return Field{Key: key, Int: int64(val), kind: fieldInt} }
+func Err(err error) Field           { return Field{Key: "err", Err: err, kind: fieldErr} }
+
+// logger interface; call sites always check Enabled first,
+// so with nil or disabled logger there are no field slices and no allocs
+type Logger interface {
+	Enabled(lvl Level) bool
+	Log(lvl Level, msg string, fields ...Field)
+}
+
+// check if engine should log on this level
+func (e *Engine) enabled(lvl Level) bool {
+	return e.Log != nil && e.Log.Enabled(lvl)
+}
+
+// log/slog adapter
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) Enabled(lvl Level) bool {
+	return s.l.Enabled(context.Background(), slog.Level(lvl))
+}
+
+func (s slogLogger) Log(lvl Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		switch f.kind {
+		case fieldInt:
+			attrs[i] = slog.Int64(f.Key, f.Int)
+		case fieldErr:
+			attrs[i] = slog.Any(f.Key, f.Err)
+		default:
+			attrs[i] = slog.String(f.Key, f.Str)
+		}
+	}
+	s.l.LogAttrs(context.Background(), slog.Level(lvl), msg, attrs...)
+}
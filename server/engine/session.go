@@ -1,6 +1,12 @@
 package engine
 
-import "sync/atomic"
+import (
+	"bytes"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
 
 // request struct, raw because it refers to bytes so we can't use it in user scope, we have Request for it
 // all slices are pointers to session Buf for zero-copy
@@ -10,18 +16,98 @@ type RawRequest struct {
 
 	Path     View   // url
 	Hcount   uint16 // header count
+	Tcount   uint16 // chunked body trailer count, trailers are in Hbuf right after headers
 	Pcount   uint16 // url param count
 	RawQuery View   // url query (? ...) raw bc i wouldn't parse it if not needed
 
 	Body View // req body
+
+	Conn uint8 // connection persistence after this request (ConnKeepAlive, ConnKeepAlive10, ConnClose)
+
+	Known [HKnownCount]uint8 // Hbuf index+1 of first well-known header (HHost...), 0 if there is none
+}
+
+// well-known headers indexed at parse time, slots of RawRequest.Known
+const (
+	HHost = iota
+	HContentType
+	HConnection
+	HAcceptEncoding
+	HAuthorization
+	HCookie
+	HTransferEncoding
+	HKnownCount
+)
+
+var knownNames = [HKnownCount][]byte{
+	HHost:             []byte("Host"),
+	HContentType:      []byte("Content-Type"),
+	HConnection:       []byte("Connection"),
+	HAcceptEncoding:   []byte("Accept-Encoding"),
+	HAuthorization:    []byte("Authorization"),
+	HCookie:           []byte("Cookie"),
+	HTransferEncoding: []byte("Transfer-Encoding"),
+}
+
+// slot of well-known header name (any case), -1 for other headers;
+// length picks the candidate so only one EqualFold is done
+func KnownHeader(name []byte) int {
+	var k int
+	switch len(name) {
+	case 4:
+		k = HHost
+	case 12:
+		k = HContentType
+	case 10:
+		k = HConnection
+	case 15:
+		k = HAcceptEncoding
+	case 13:
+		k = HAuthorization
+	case 6:
+		k = HCookie
+	case 17:
+		k = HTransferEncoding
+	default:
+		return -1
+	}
+	if !bytes.EqualFold(name, knownNames[k]) {
+		return -1
+	}
+	return k
 }
 
+// record header at Hbuf index i in its known slot (first one wins)
+func (r *RawRequest) Index(name []byte, i int) int {
+	k := KnownHeader(name)
+	if k >= 0 && r.Known[k] == 0 {
+		r.Known[k] = uint8(i + 1)
+	}
+	return k
+}
+
+// connection persistence, decided by parser from http version and Connection header
+const (
+	ConnKeepAlive   uint8 = iota // HTTP/1.1 default, nothing to announce in response
+	ConnKeepAlive10              // HTTP/1.0 with Connection: keep-alive, response must confirm it
+	ConnClose                    // conn is closed after response
+)
+
 // view for slice
 type View struct {
 	St  uint16
 	End uint16
 }
 
+// value of well-known header (HHost...), nil if request has none
+func (s *Session) KnownHeader(k int) []byte {
+	i := s.Req.Known[k]
+	if i == 0 {
+		return nil
+	}
+	return s.Hbuf[i-1].Val.AsBuf(s)
+}
+
 // view as buffer based on Session
 func (v *View) AsBuf(s *Session) []byte {
 	return s.Buf[v.St:v.End]
@@ -38,6 +124,23 @@ type Param struct {
 	Val View
 }
 
+// state of chunked body decoder kept between reads of one request,
+// fields belong to protocol.ChunkedDecoder (same type), engine only resets it
+type ChunkState struct {
+	State   uint8
+	Digits  uint8
+	Size    int64 // rest of current chunk
+	Read    int   // raw bytes consumed in total
+	Written int   // decoded bytes in total
+	Trailer int   // raw offset where trailer section starts
+}
+
+// session flags, set by upper layers (parser, server) for per-connection state
+const (
+	FlagProxied   uint8 = 1 << iota // proxy header already consumed (or not expected)
+	FlagContinued                   // 100 Continue is sent for request being read
+)
+
 // header for response, maybe i would redo it to views
 type Header struct {
 	Key, Val []byte
@@ -47,6 +150,7 @@ type Header struct {
 // it manages buffers and fd for HTTPRequest, session is atomical instance for 1 socket fd !
 // buf, offset for raw data, hbuf and req is pre-allocated buffer for headers and RawRequest struct from pool
 type Session struct {
+	eng    *Engine // owner engine (pools, date cache), nil for sessions made by hand in tests
 	raw    any
 	bufraw any
 	tnext  *Session
@@ -59,6 +163,36 @@ type Session struct {
 	Hbuf   [16]HeaderView
 	Req    RawRequest
 
+	// client address: socket peer, replaced by PROXY header source if it is enabled
+	Remote netip.AddrPort
+	Flags  uint8
+
+	// chunked request body decoded so far, reset after every request
+	Chunk ChunkState
+
+	// out buffer for responses of current Parse pass, flushed once by worker
+	out      []byte
+	outraw   any
+	batching bool
+
+	// upgraded conns (websocket etc): own read handler, close hook, no idle eviction
+	handler    HandleConn
+	closeHook  func(s *Session)
+	persistent bool
+
+	// if set, responses go here instead of http/1 writes to fd (http2 streams)
+	Responder Responder
+
+	// close conn after responses of current pass are written
+	closeAfter bool
+
+	// output socket didn't take, sent by worker on EPOLLOUT (see write.go)
+	wmu       sync.Mutex
+	pend      []byte
+	werr      error         // write failed, every next write gets it
+	wready    chan struct{} // closed when pend is sent
+	shutWrite bool          // FIN goes after pend
+
 	inWork atomic.Bool
 	_      [12]byte
 }
@@ -67,12 +201,127 @@ type Session struct {
 func (s *Session) Reset() {
 	s.Fd = 0
 	s.Offset = 0
+	s.Flags = 0
+	s.Chunk = ChunkState{}
+	s.Remote = netip.AddrPort{}
 
 	s.tnext = nil
 	s.tprev = nil
 	s.inWork.Store(false)
 
+	s.handler = nil
+	s.closeHook = nil
+	s.persistent = false
+	s.Responder = nil
+	s.closeAfter = false
+	s.resetPending()
+
 	s.Req = RawRequest{}
 	s.Req.Hcount = 0
 	s.Req.Pcount = 0
 }
+
+// cached Date header value of session engine (nil if session has no engine)
+func (s *Session) Date() []byte {
+	if s.eng == nil {
+		return nil
+	}
+	return s.eng.Date()
+}
+
+// response sink for sessions that are not plain http/1 conns,
+// headers and body are valid only during call
+type Responder interface {
+	Respond(s *Session, code int, headers []Header, body []byte) error
+}
+
+// session for one request multiplexed over parent conn (http2 stream):
+// same engine, fd and peer, own buffer; must be given back with ReleaseStream
+func NewStream(parent *Session) *Session {
+	e := parent.eng
+	if e == nil {
+		return &Session{Fd: parent.Fd, Remote: parent.Remote, Buf: make([]byte, maxRawSize)}
+	}
+
+	raw := e.sessionPool.Get()
+	s := raw.(*Session)
+	s.Reset()
+	s.raw = raw
+	s.eng = e
+	s.Fd = parent.Fd
+	s.Remote = parent.Remote
+
+	s.bufraw = e.bufPool.Get()
+	buf := s.bufraw.([]byte)
+	s.Buf = buf[:cap(buf)]
+	return s
+}
+
+func ReleaseStream(s *Session) {
+	if s.eng != nil {
+		s.eng.releaseSession(s)
+	}
+}
+
+// close conn after responses of current pass are written:
+// engine shuts down writing side and drops everything client sends until it closes
+func (s *Session) CloseAfterWrite() {
+	s.closeAfter = true
+}
+
+// take over conn from engine callback (protocol upgrade): next reads go to h,
+// should be called from handler on worker goroutine
+func (s *Session) Hijack(h HandleConn) {
+	s.handler = h
+}
+
+func (s *Session) Hijacked() bool {
+	return s.handler != nil
+}
+
+// f is called once when engine closes conn (peer closed, error, eviction, shutdown),
+// before fd is closed, so f can safely stop writers
+func (s *Session) OnClose(f func(s *Session)) {
+	s.closeHook = f
+}
+
+// persistent session is never evicted by idle timer (long-lived streams)
+func (s *Session) SetPersistent(v bool) {
+	s.persistent = v
+}
+
+// write hdr and body right now, bypassing batch buffer; what socket doesn't take is queued
+// and sent from event loop (ErrSlowPeer when queue is full), so call never waits for client;
+// can be called from any goroutine, caller must serialize writes and make sure session is alive
+func (s *Session) WriteDirect(hdr, body []byte) (int, error) {
+	var iov [2]syscall.Iovec
+	cnt := 0
+	if len(hdr) > 0 {
+		iov[cnt].Base = &hdr[0]
+		iov[cnt].SetLen(len(hdr))
+		cnt++
+	}
+	if len(body) > 0 {
+		iov[cnt].Base = &body[0]
+		iov[cnt].SetLen(len(body))
+		cnt++
+	}
+	if cnt == 0 {
+		return 0, nil
+	}
+	return s.writev(iov[:cnt])
+}
+
+// shutdown socket in both directions, engine notices it on next read and closes session;
+// safe from any goroutine while session is alive
+func (s *Session) Shutdown() error {
+	return syscall.Shutdown(int(s.Fd), syscall.SHUT_RDWR)
+}
+
+// call close hook once
+func (s *Session) closed() {
+	if f := s.closeHook; f != nil {
+		s.closeHook = nil
+		f(s)
+	}
+}
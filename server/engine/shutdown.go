@@ -3,37 +3,74 @@ package engine
 import (
 	"io"
 	"os"
+	"strconv"
 	"syscall"
-	"time"
 )
 
-// stops the server and write logs to stdout (default os.Stdout)
+// stops the server, progress goes to engine logger; without logger it is written
+// as plain lines to stdout (default os.Stdout) as it was before logger was added
 func (e *Engine) StopServer(stdout *io.Writer) {
-	var out io.Writer
-	if stdout == nil {
-		out = os.Stdout
-	} else {
-		out = *stdout
+	log := e.Log
+	if log == nil {
+		w := io.Writer(os.Stdout)
+		if stdout != nil {
+			w = *stdout
+		}
+		log = lineLogger{w: w}
 	}
 
-	syscall.Close(e.lsfd)
-	out.Write([]byte("\nclosing listening socket...\n"))
-
-	for _, ch := range e.jobsarr {
-		close(ch)
+	e.init()
+	if !e.closing.CompareAndSwap(false, true) {
+		return
+	}
+	if !e.started.Load() {
+		return
 	}
-	out.Write([]byte("closing worker channels...\n"))
 
-	time.Sleep(time.Millisecond * 500)
-	syscall.Close(e.epollfd)
-	out.Write([]byte("closing epoll descpiptor...\n"))
+	// event loop notices closing flag after current epoll wait, stops workers
+	// and closes listening socket and epoll itself
+	<-e.done
+	if log.Enabled(LevelInfo) {
+		log.Log(LevelInfo, "listening socket closed, workers stopped")
+	}
 
+	closed := 0
 	for i := range e.sessions {
 		se := e.sessions[i].Swap(nil)
 
 		if se != nil {
+			se.closed()
+			se.resetPending()
 			syscall.Close(int(se.Fd))
+			closed++
+		}
+	}
+	if log.Enabled(LevelInfo) {
+		log.Log(LevelInfo, "server is down", Int("sessions_closed", closed))
+	}
+}
+
+// plain text progress of StopServer for callers without logger
+type lineLogger struct {
+	w io.Writer
+}
+
+func (lineLogger) Enabled(lvl Level) bool { return lvl >= LevelInfo }
+
+func (l lineLogger) Log(lvl Level, msg string, fields ...Field) {
+	b := append([]byte(nil), msg...)
+	for _, f := range fields {
+		b = append(b, ' ')
+		b = append(b, f.Key...)
+		b = append(b, '=')
+		switch f.kind {
+		case fieldInt:
+			b = strconv.AppendInt(b, f.Int, 10)
+		case fieldErr:
+			b = append(b, f.Err.Error()...)
+		default:
+			b = append(b, f.Str...)
 		}
 	}
-	out.Write([]byte("! server is down...\n"))
+	l.w.Write(append(b, '\n'))
 }
@@ -0,0 +1,113 @@
+// socket options for listening socket and accepted conns
+package engine
+
+import (
+	"syscall"
+	"time"
+)
+
+// TCP_FASTOPEN is missing in syscall package
+const tcpFastOpen = 0x17
+
+// kernel side settings of listener, applied in listenSocket and on every accepted fd;
+// zero int/duration fields keep kernel defaults
+type ListenerOptions struct {
+	ReuseAddr bool // SO_REUSEADDR, restart without EADDRINUSE while old conns are in TIME_WAIT
+	NoDelay   bool // TCP_NODELAY on accepted conns
+
+	FastOpen    int           // TCP_FASTOPEN queue length, 0 disables
+	DeferAccept time.Duration // TCP_DEFER_ACCEPT, wake accept only when data arrived (second precision)
+
+	KeepAlive    bool          // SO_KEEPALIVE on accepted conns
+	KeepIdle     time.Duration // TCP_KEEPIDLE
+	KeepInterval time.Duration // TCP_KEEPINTVL
+	KeepCount    int           // TCP_KEEPCNT
+
+	RcvBuf, SndBuf int // SO_RCVBUF, SO_SNDBUF in bytes
+}
+
+// options used when Engine.Listener is nil
+func DefaultListenerOptions() ListenerOptions {
+	return ListenerOptions{
+		ReuseAddr: true,
+		NoDelay:   true,
+	}
+}
+
+// listener options of engine, defaults if not set
+func (e *Engine) listenerOptions() ListenerOptions {
+	if e.Listener == nil {
+		return DefaultListenerOptions()
+	}
+	return *e.Listener
+}
+
+// options that should be set before bind
+func (o *ListenerOptions) applyBind(fd int) error {
+	if o.ReuseAddr {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	// buffer sizes on listener are inherited by accepted sockets,
+	// and rcvbuf must be set before listen to affect tcp window scale
+	if o.RcvBuf > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.RcvBuf); err != nil {
+			return err
+		}
+	}
+	if o.SndBuf > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SndBuf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options for listening socket after bind
+func (o *ListenerOptions) applyListen(fd int) error {
+	if o.FastOpen > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpFastOpen, o.FastOpen); err != nil {
+			return err
+		}
+	}
+	if o.DeferAccept > 0 {
+		secs := max(int(o.DeferAccept/time.Second), 1)
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, secs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options for every accepted conn
+func (o *ListenerOptions) applyConn(fd int) error {
+	if o.NoDelay {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1); err != nil {
+			return err
+		}
+	}
+	if !o.KeepAlive {
+		return nil
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if o.KeepIdle > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, max(int(o.KeepIdle/time.Second), 1)); err != nil {
+			return err
+		}
+	}
+	if o.KeepInterval > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, max(int(o.KeepInterval/time.Second), 1)); err != nil {
+			return err
+		}
+	}
+	if o.KeepCount > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, o.KeepCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
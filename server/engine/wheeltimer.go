@@ -1,7 +1,6 @@
 package engine
 
 import (
-	"sync/atomic"
 	"syscall"
 )
 
@@ -51,7 +50,7 @@ func (tw *TimerWheel) Update(s *Session) {
 }
 
 // start goroutine that kills processes with timeout
-func (tw *TimerWheel) killSharded(ss []atomic.Pointer[Session]) {
+func (tw *TimerWheel) killSharded(e *Engine) {
 	tw.cursor = (tw.cursor + 1) & tw.mask
 
 	explisthead := tw.slots[tw.cursor]
@@ -66,21 +65,25 @@ func (tw *TimerWheel) killSharded(ss []atomic.Pointer[Session]) {
 			continue
 		}
 
+		// long-lived streams are not idle, move them to next round
+		if cur.persistent {
+			tw.Update(cur)
+			cur = next
+			continue
+		}
+
 		// ATOMICALLY compare and swap
-		if ss[cur.Fd].CompareAndSwap(cur, nil) {
+		if e.sessions[cur.Fd].CompareAndSwap(cur, nil) {
 			cur.tnext = nil
 			cur.tprev = nil
 
-			if cur.bufraw != nil {
-				bufPool.Put(cur.bufraw)
-				cur.Buf = nil
-				cur.bufraw = nil
-			}
-
-			sessionPool.Put(cur.raw)
+			fd := int(cur.Fd)
+			e.releaseSession(cur)
+			syscall.Close(fd)
 
-			syscall.Close(int(cur.Fd))
-			cur.Reset()
+			if e.enabled(LevelDebug) {
+				e.Log.Log(LevelDebug, "idle session evicted", Int("fd", fd))
+			}
 		}
 
 		cur = next
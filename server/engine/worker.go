@@ -2,55 +2,58 @@
 package engine
 
 import (
-	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
 	maxRawSize = 1<<16 - 1
 )
 
-// pool for sessions
-var (
-	// bufPool for sessions buffers
-	bufPool = sync.Pool{
-		New: func() any {
-			return make([]byte, maxRawSize)
-		},
-	}
+// idle conns are evicted after this (timer wheel ticks once a second)
+const IdleTimeout = 20 * time.Second
 
-	// pool for sessions
-	sessionPool = sync.Pool{
-		New: func() any {
-			return &Session{}
-		},
-	}
-)
+// pools are owned by Engine (not package globals), so every server has its own buffers
+func newBuf() any     { return make([]byte, maxRawSize) }
+func newSession() any { return &Session{} }
 
 // handle RawRequest // fd -> parser -> router -> handler -> write & close
-func workerEpoll(epollfd int, jobs chan int, Sessions []atomic.Pointer[Session], cb handleConn) {
-	tw := NewWheel(20)
+func (e *Engine) workerEpoll(jobs chan int, cb HandleConn, cpu int) {
+	if cpu >= 0 {
+		unpin, err := pinThread(cpu)
+		if err != nil {
+			if e.enabled(LevelWarn) {
+				e.Log.Log(LevelWarn, "worker pinning failed", Int("cpu", cpu), Err(err))
+			}
+		} else {
+			defer unpin()
+		}
+	}
+	tw := NewWheel(int(IdleTimeout / time.Second))
+	Sessions := e.sessions
 
 	for fd := range jobs {
 		if fd == -1 {
-			tw.killSharded(Sessions)
+			tw.killSharded(e)
 			continue
 		}
 
 		s := Sessions[fd].Load() // load pointer atomically so we don't get invalid ptr
 		if s == nil {
-			nsRaw := sessionPool.Get()
+			nsRaw := e.sessionPool.Get()
 			ns := nsRaw.(*Session)
 			ns.Reset()
 			ns.Fd = uint32(fd)
 			ns.raw = nsRaw
+			ns.eng = e
+			ns.Remote = peerAddr(fd)
 
 			if Sessions[fd].CompareAndSwap(nil, ns) {
 				s = ns
 				tw.Update(s)
 			} else {
-				sessionPool.Put(nsRaw)
+				e.sessionPool.Put(nsRaw)
 				s = Sessions[fd].Load()
 			}
 		}
@@ -58,10 +61,19 @@ func workerEpoll(epollfd int, jobs chan int, Sessions []atomic.Pointer[Session],
 			continue
 		}
 
+		// output client didn't take yet goes first, conn is not read until it is gone
+		if pending, err := s.flushPending(); err != nil || pending {
+			if err != nil && e.closeSession(s, fd) {
+				continue
+			}
+			e.rearm(s)
+			continue
+		}
+
 		// give buffer to session only when needed
 		// it is useful when we have many keep-alive conns thst store bufs but not working
 		if s.Buf == nil {
-			bufraw := bufPool.Get()
+			bufraw := e.bufPool.Get()
 			buf := bufraw.([]byte)
 
 			s.bufraw = bufraw
@@ -70,18 +82,10 @@ func workerEpoll(epollfd int, jobs chan int, Sessions []atomic.Pointer[Session],
 
 		n, err := syscall.Read(fd, s.Buf[s.Offset:])
 		if (err != nil && err != syscall.EAGAIN) || n == 0 || s.Offset > maxRawSize {
-			if Sessions[fd].CompareAndSwap(s, nil) {
-
-				if s.bufraw != nil {
-					bufPool.Put(s.bufraw)
-					s.bufraw = nil
-					s.Buf = nil
-				}
-
-				s.Reset()
-				sessionPool.Put(s.raw)
-				syscall.Close(fd)
-				atomic.AddInt64(&Stats.ActiveConn, -1)
+			if err != nil && err != syscall.EAGAIN && e.enabled(LevelDebug) {
+				e.Log.Log(LevelDebug, "read failed", Int("fd", fd), Err(err))
+			}
+			if e.closeSession(s, fd) {
 				continue
 			}
 		}
@@ -91,22 +95,102 @@ func workerEpoll(epollfd int, jobs chan int, Sessions []atomic.Pointer[Session],
 			atomic.AddUint64(&Stats.BytesSent, uint64(n))
 
 			s.Offset += uint32(n)
-			shouldRelease, _ := cb(s)
+
+			h, upgraded := cb, s.handler != nil
+			if upgraded {
+				h = s.handler
+			}
+
+			// responses of this pass are collected in session out buffer and sent at once
+			s.batching = true
+			shouldRelease, err := h(s)
+			// conn was upgraded during this pass, bytes after handshake belong to new protocol
+			if err == nil && !upgraded && s.handler != nil && s.Offset > 0 {
+				shouldRelease, err = s.handler(s)
+			}
+			s.batching = false
+			if ferr := s.Flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+
+			// graceful close: client gets FIN after last response, and closing fd only
+			// when client closes too means unread pipelined data can't turn into RST
+			if err == nil && s.closeAfter {
+				s.closeAfter = false
+				s.shutdownWrite()
+				s.handler = drain
+				s.Offset = 0
+				shouldRelease = true
+			}
+
+			// callback rejected the stream (bad proxy header, invalid request), drop the conn
+			if err != nil && e.closeSession(s, fd) {
+				continue
+			}
 
 			if shouldRelease {
-				bufPool.Put(s.bufraw)
+				e.bufPool.Put(s.bufraw)
+				s.bufraw = nil
 				s.Buf = nil
 				s.Offset = 0
 			}
 		}
 
-		s.inWork.Store(false)
+		e.rearm(s)
+	}
 
-		ev := syscall.EpollEvent{
-			Events: syscall.EPOLLIN | syscall.EPOLLONESHOT,
-			Fd:     int32(fd),
-		}
-		syscall.EpollCtl(epollfd, syscall.EPOLL_CTL_MOD, fd, &ev)
+}
+
+// give fd back to epoll (oneshot); done under write lock, so writers on other goroutines
+// either see session in work or find it armed
+func (e *Engine) rearm(s *Session) {
+	s.wmu.Lock()
+	s.inWork.Store(false)
+	e.arm(s)
+	s.wmu.Unlock()
+}
+
+// wait for room in socket while output is pending, for input otherwise; wmu must be held
+func (e *Engine) arm(s *Session) {
+	ev := syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLONESHOT,
+		Fd:     int32(s.Fd),
+	}
+	if len(s.pend) > 0 {
+		ev.Events = syscall.EPOLLOUT | syscall.EPOLLONESHOT
+	}
+	syscall.EpollCtl(e.epollfd, syscall.EPOLL_CTL_MOD, int(s.Fd), &ev)
+}
+
+// read handler of conns that are closing, everything is dropped
+func drain(s *Session) (bool, error) {
+	s.Offset = 0
+	return true, nil
+}
+
+// remove session from table, give its buffers back to pools and close fd,
+// returns false if session was already removed by someone else
+func (e *Engine) closeSession(s *Session, fd int) bool {
+	if !e.sessions[fd].CompareAndSwap(s, nil) {
+		return false
+	}
+
+	e.releaseSession(s)
+	syscall.Close(fd)
+	atomic.AddInt64(&Stats.ActiveConn, -1)
+	return true
+}
+
+// give session buffers and session itself back to engine pools
+func (e *Engine) releaseSession(s *Session) {
+	s.closed()
+	s.releaseOut()
+	if s.bufraw != nil {
+		e.bufPool.Put(s.bufraw)
+		s.bufraw = nil
+		s.Buf = nil
 	}
 
+	s.Reset()
+	e.sessionPool.Put(s.raw)
 }
@@ -1,7 +1,21 @@
 package engine
 
 import (
+	"errors"
 	"syscall"
+	"unsafe"
+)
+
+// output client doesn't read is queued on session up to this, then writes fail and conn is dropped;
+// conn that doesn't read at all is evicted by idle timer like any other
+const maxPending = 4 << 20
+
+var ErrSlowPeer = errors.New("engine: peer doesn't read, output queue is full")
+
+const (
+	// flush batched responses when less than this is left in out buffer,
+	// so next header block always fits
+	outReserve = maxRawSize / 2
 )
 
 var (
@@ -15,21 +29,331 @@ type buildFunc func(dst []byte) int
 // get buf from pool, write response and put it back
 // so we don't alloc new bufs for every resp
 func WriteBuf(s *Session, cb buildFunc) (int, error) {
-	rawo := bufPool.Get()
+	if s.batching {
+		return s.queue(cb, nil)
+	}
+
+	// session without engine (made by hand) has no pools, so just alloc
+	if s.eng == nil {
+		out := make([]byte, maxRawSize)
+		return syscall.Write(int(s.Fd), out[:cb(out)])
+	}
+
+	rawo := s.eng.bufPool.Get()
 	out := rawo.([]byte)
 	out = out[:cap(out)]
 
 	n := cb(out)
 	n, err := syscall.Write(int(s.Fd), out[:n])
 
-	bufPool.Put(rawo)
+	s.eng.bufPool.Put(rawo)
 	return n, err
 }
 
+// build header block into pooled buf and send it with caller-owned body in one writev,
+// body is not copied so it can be any size
+func WriteV(s *Session, cb buildFunc, body []byte) (int, error) {
+	if s.batching {
+		return s.queue(cb, body)
+	}
+
+	var (
+		rawo any
+		out  []byte
+	)
+	if s.eng == nil {
+		out = make([]byte, maxRawSize)
+	} else {
+		rawo = s.eng.bufPool.Get()
+		out = rawo.([]byte)
+		out = out[:cap(out)]
+	}
+
+	n := cb(out)
+	iov := [2]syscall.Iovec{}
+	iov[0].Base = &out[0]
+	iov[0].SetLen(n)
+	cnt := 1
+	if len(body) > 0 {
+		iov[1].Base = &body[0]
+		iov[1].SetLen(len(body))
+		cnt = 2
+	}
+
+	w, err := s.writev(iov[:cnt])
+
+	if rawo != nil {
+		s.eng.bufPool.Put(rawo)
+	}
+	return w, err
+}
+
+// writev until all iovecs are sent or socket buffer is full, never waits;
+// returns bytes written and unsent part of iov (EAGAIN is not an error, caller queues the rest)
+func writevNB(fd int, iov []syscall.Iovec) (int, []syscall.Iovec, error) {
+	total := 0
+	for len(iov) > 0 {
+		r, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), uintptr(len(iov)))
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			if errno == syscall.EAGAIN {
+				return total, iov, nil
+			}
+			return total, iov, errno
+		}
+
+		w := int(r)
+		total += w
+		iov = skipIovec(iov, w)
+	}
+	return total, nil, nil
+}
+
+// skip fully written iovecs and move base of partial one
+func skipIovec(iov []syscall.Iovec, w int) []syscall.Iovec {
+	for len(iov) > 0 && w >= int(iov[0].Len) {
+		w -= int(iov[0].Len)
+		iov = iov[1:]
+	}
+	if len(iov) > 0 && w > 0 {
+		iov[0].Base = (*byte)(unsafe.Add(unsafe.Pointer(iov[0].Base), w))
+		iov[0].SetLen(int(iov[0].Len) - w)
+	}
+	return iov
+}
+
 func Write404(s *Session) {
-	syscall.Write(int(s.Fd), res404)
+	writeStatic(s, res404)
 }
 
 func Write500(s *Session) {
-	syscall.Write(int(s.Fd), res500)
+	writeStatic(s, res500)
+}
+
+// pre-built response, goes to batch like any other;
+// it says Connection: close, so conn is closed after it
+func writeStatic(s *Session, res []byte) {
+	s.CloseAfterWrite()
+	if s.batching {
+		s.queue(func(dst []byte) int { return copy(dst, res) }, nil)
+		return
+	}
+	var iov [1]syscall.Iovec
+	iov[0].Base = &res[0]
+	iov[0].SetLen(len(res))
+	s.writev(iov[:])
+}
+
+// append response to session out buffer (responses of one Parse pass are sent in one write),
+// big body that doesn't fit goes out with buffered data in one writev (copied only if socket is full)
+func (s *Session) queue(cb buildFunc, body []byte) (int, error) {
+	if s.out == nil {
+		if s.eng != nil {
+			s.outraw = s.eng.bufPool.Get()
+			s.out = s.outraw.([]byte)[:0]
+		} else {
+			s.out = make([]byte, 0, maxRawSize)
+		}
+	}
+	if cap(s.out)-len(s.out) < outReserve {
+		if err := s.Flush(); err != nil {
+			return 0, err
+		}
+		return s.queue(cb, body)
+	}
+
+	n := cb(s.out[len(s.out):cap(s.out)])
+	s.out = s.out[:len(s.out)+n]
+
+	if len(body) <= cap(s.out)-len(s.out) {
+		s.out = append(s.out, body...)
+		return n + len(body), nil
+	}
+
+	var iov [2]syscall.Iovec
+	iov[0].Base = &s.out[0]
+	iov[0].SetLen(len(s.out))
+	iov[1].Base = &body[0]
+	iov[1].SetLen(len(body))
+
+	w, err := s.writev(iov[:])
+	s.releaseOut()
+	return w, err
+}
+
+// write batched responses and give out buffer back to pool
+func (s *Session) Flush() error {
+	if len(s.out) == 0 {
+		s.releaseOut()
+		return nil
+	}
+
+	var iov [1]syscall.Iovec
+	iov[0].Base = &s.out[0]
+	iov[0].SetLen(len(s.out))
+
+	_, err := s.writev(iov[:])
+	s.releaseOut()
+	return err
+}
+
+func (s *Session) releaseOut() {
+	if s.outraw != nil {
+		s.eng.bufPool.Put(s.outraw)
+		s.outraw = nil
+	}
+	s.out = nil
+}
+
+// every socket write goes here, so engine can count syscalls;
+// what socket doesn't take is copied to pending queue and sent by worker when fd is writable (EPOLLOUT),
+// so no goroutine ever waits for slow client. Returns bytes accepted (written or queued)
+func (s *Session) writev(iov []syscall.Iovec) (int, error) {
+	total := 0
+	for i := range iov {
+		total += int(iov[i].Len)
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.werr != nil {
+		return 0, s.werr
+	}
+	// something is queued already, order must be kept
+	if len(s.pend) > 0 {
+		return total, s.enqueue(iov)
+	}
+
+	if s.eng != nil {
+		s.eng.writes.Add(1)
+	}
+	w, rest, err := writevNB(int(s.Fd), iov)
+	if err != nil {
+		s.werr = err
+		return w, err
+	}
+	if len(rest) == 0 {
+		return total, nil
+	}
+	if err := s.enqueue(rest); err != nil {
+		return w, err
+	}
+	// worker arms EPOLLOUT itself when it gives fd back
+	if s.eng != nil && !s.inWork.Load() {
+		s.eng.arm(s)
+	}
+	return total, nil
+}
+
+// copy unsent bytes to pending queue, wmu must be held
+func (s *Session) enqueue(iov []syscall.Iovec) error {
+	n := len(s.pend)
+	for i := range iov {
+		n += int(iov[i].Len)
+	}
+	if n > maxPending {
+		s.werr = ErrSlowPeer
+		return ErrSlowPeer
+	}
+	for i := range iov {
+		s.pend = append(s.pend, unsafe.Slice(iov[i].Base, iov[i].Len)...)
+	}
+	return nil
+}
+
+// send queued output (worker, when fd is writable), true if some is still left
+func (s *Session) flushPending() (bool, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.werr != nil {
+		return false, s.werr
+	}
+	if len(s.pend) == 0 {
+		return false, nil
+	}
+
+	var iov [1]syscall.Iovec
+	iov[0].Base = &s.pend[0]
+	iov[0].SetLen(len(s.pend))
+	if s.eng != nil {
+		s.eng.writes.Add(1)
+	}
+	w, _, err := writevNB(int(s.Fd), iov[:])
+	if err != nil {
+		s.werr = err
+		return false, err
+	}
+	s.pend = s.pend[w:]
+	if len(s.pend) > 0 {
+		return true, nil
+	}
+
+	s.pend = nil
+	if s.wready != nil {
+		close(s.wready)
+		s.wready = nil
+	}
+	if s.shutWrite {
+		s.shutWrite = false
+		syscall.Shutdown(int(s.Fd), syscall.SHUT_WR)
+	}
+	return false, nil
+}
+
+// send FIN after all queued output is written
+func (s *Session) shutdownWrite() {
+	s.wmu.Lock()
+	if len(s.pend) > 0 {
+		s.shutWrite = true
+	} else {
+		syscall.Shutdown(int(s.Fd), syscall.SHUT_WR)
+	}
+	s.wmu.Unlock()
+}
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// bytes waiting for client to read them
+func (s *Session) Pending() int {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return len(s.pend)
+}
+
+// closed when pending output is sent or conn is gone, for producers on their own goroutines
+// (worker must never wait on it, it is the one that sends pending output)
+func (s *Session) Writable() <-chan struct{} {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if len(s.pend) == 0 || s.werr != nil {
+		return closedChan
+	}
+	if s.wready == nil {
+		s.wready = make(chan struct{})
+	}
+	return s.wready
+}
+
+// drop queued output and wake waiters, session is closed or reused
+func (s *Session) resetPending() {
+	s.wmu.Lock()
+	s.pend = nil
+	s.werr = nil
+	s.shutWrite = false
+	if s.wready != nil {
+		close(s.wready)
+		s.wready = nil
+	}
+	s.wmu.Unlock()
+}
+
+// number of response writes done by engine (for metrics and benchmarks)
+func (e *Engine) WriteCalls() uint64 {
+	return e.writes.Load()
 }
@@ -0,0 +1,246 @@
+// in-process test server and client for end-to-end tests,
+// no fixed ports and no sleeps: server readiness is signaled by engine
+package goservertest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	srv "github.com/s00inx/goserver/server"
+)
+
+// how long Start waits for the server to become ready
+const readyTimeout = 5 * time.Second
+
+// running test server, Addr is ephemeral 127.0.0.1 listener
+type Server struct {
+	Srv  *srv.Server
+	Addr string
+
+	errc chan error
+}
+
+// start srv on 127.0.0.1 with ephemeral port and wait until it accepts conns
+func NewServer(s *srv.Server) (*Server, error) {
+	ts := &Server{Srv: s, errc: make(chan error, 1)}
+
+	go func() {
+		ts.errc <- s.Run([4]byte{127, 0, 0, 1}, 0)
+	}()
+
+	select {
+	case <-s.Ready():
+	case err := <-ts.errc:
+		if err == nil {
+			err = errors.New("goservertest: server stopped before ready")
+		}
+		return nil, err
+	case <-time.After(readyTimeout):
+		return nil, errors.New("goservertest: server is not ready")
+	}
+
+	ts.Addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port()))
+	return ts, nil
+}
+
+// same as NewServer but fails the test on error and stops server on cleanup
+func Start(tb testing.TB, s *srv.Server) *Server {
+	tb.Helper()
+
+	ts, err := NewServer(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(ts.Close)
+	return ts
+}
+
+// stop server and wait for event loop to exit
+func (ts *Server) Close() {
+	out := io.Discard
+	ts.Srv.Stop(&out)
+}
+
+// connect to server over TCP
+func (ts *Server) Dial() (*Client, error) {
+	conn, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// connect to server over socketpair, one end goes to engine directly (no port involved)
+func (ts *Server) Pipe() (*Client, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.Srv.Attach(fds[0]); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fds[1]), "goservertest-pipe")
+	defer f.Close() // FileConn dups fd
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// same as Dial/Pipe but fail the test on error and close conn on cleanup
+func (ts *Server) Client(tb testing.TB) *Client {
+	tb.Helper()
+
+	c, err := ts.Dial()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (ts *Server) PipeClient(tb testing.TB) *Client {
+	tb.Helper()
+
+	c, err := ts.Pipe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+// structured request, Host defaults to localhost and Content-Length is set from Body
+type Request struct {
+	Method string // GET if empty
+	Path   string // / if empty
+	Header [][2]string
+	Body   []byte
+}
+
+// serialize request to HTTP/1.1 wire format
+func (r *Request) Bytes() []byte {
+	method, path := r.Method, r.Path
+	if method == "" {
+		method = "GET"
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	b := make([]byte, 0, 128+len(r.Body))
+	b = append(b, method...)
+	b = append(b, ' ')
+	b = append(b, path...)
+	b = append(b, " HTTP/1.1\r\n"...)
+
+	hasHost := false
+	for _, h := range r.Header {
+		if http.CanonicalHeaderKey(h[0]) == "Host" {
+			hasHost = true
+		}
+		b = append(b, h[0]...)
+		b = append(b, ": "...)
+		b = append(b, h[1]...)
+		b = append(b, "\r\n"...)
+	}
+	if !hasHost {
+		b = append(b, "Host: localhost\r\n"...)
+	}
+	if len(r.Body) > 0 {
+		b = append(b, "Content-Length: "...)
+		b = strconv.AppendInt(b, int64(len(r.Body)), 10)
+		b = append(b, "\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+	return append(b, r.Body...)
+}
+
+// parsed response with fully read body
+type Response struct {
+	Proto   string
+	Code    int
+	Header  http.Header
+	Trailer http.Header
+	Body    []byte
+	Close   bool // server asked to close conn (Connection: close)
+}
+
+// small client over a single connection, supports pipelining:
+// Send several requests and ReadResponse for each of them in order
+type Client struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// read deadline for every response, 0 means no deadline
+	Timeout time.Duration
+}
+
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, br: bufio.NewReader(conn), Timeout: readyTimeout}
+}
+
+// underlying connection, for tests that need half-close or raw reads
+func (c *Client) Conn() net.Conn { return c.conn }
+
+// buffered reader of conn, for reading non-http data after protocol upgrade
+func (c *Client) Reader() *bufio.Reader { return c.br }
+
+func (c *Client) Close() error { return c.conn.Close() }
+
+// write raw bytes as is (malformed requests, fragments)
+func (c *Client) WriteRaw(b []byte) error {
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// write request without waiting for response
+func (c *Client) Send(r Request) error {
+	return c.WriteRaw(r.Bytes())
+}
+
+// read next response from connection
+func (c *Client) ReadResponse() (*Response, error) {
+	if c.Timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	}
+
+	res, err := http.ReadResponse(c.br, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Proto:   res.Proto,
+		Code:    res.StatusCode,
+		Header:  res.Header,
+		Trailer: res.Trailer,
+		Body:    body,
+		Close:   res.Close,
+	}, nil
+}
+
+// send request and read its response
+func (c *Client) Do(r Request) (*Response, error) {
+	if err := c.Send(r); err != nil {
+		return nil, err
+	}
+	return c.ReadResponse()
+}
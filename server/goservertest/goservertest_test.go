@@ -0,0 +1,808 @@
+package goservertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	srv "github.com/s00inx/goserver/server"
+	"github.com/s00inx/goserver/server/engine"
+)
+
+func newTestServer(t *testing.T) *Server {
+	s := srv.New()
+
+	s.Use(func(c *srv.Context) {
+		c.SetHeader([]byte("X-Mw"), []byte("1"))
+		c.Next()
+	})
+	s.Get("/ping", func(c *srv.Context) {
+		c.SendDirect(200, []byte("pong"))
+	})
+	s.Group("/user").Get("/:id", func(c *srv.Context) {
+		c.SendDirect(200, c.Param([]byte("id")))
+	})
+	s.Post("/echo", func(c *srv.Context) {
+		c.SendDirect(201, c.Body())
+	})
+
+	return Start(t, s)
+}
+
+func TestServer_EndToEnd(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name string
+		req  Request
+		code int
+		body string
+	}{
+		{"Static Route", Request{Path: "/ping"}, 200, "pong"},
+		{"Param Route", Request{Path: "/user/42"}, 200, "42"},
+		{"Post Body", Request{Method: "POST", Path: "/echo", Body: []byte("hello")}, 201, "hello"},
+		{"Not Found", Request{Path: "/missing"}, 404, "Not Found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ts.Client(t)
+
+			res, err := c.Do(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.code || string(res.Body) != tt.body {
+				t.Errorf("expected %d %q, got %d %q", tt.code, tt.body, res.Code, res.Body)
+			}
+		})
+	}
+}
+
+func TestServer_Middleware(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.PipeClient(t)
+
+	res, err := c.Do(Request{Path: "/ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Get("X-Mw") != "1" {
+		t.Errorf("middleware header missing: %v", res.Header)
+	}
+	if res.Header.Get("Date") == "" {
+		t.Error("Date header missing")
+	}
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.PipeClient(t)
+
+	var raw []byte
+	ids := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+	for _, id := range ids {
+		r := Request{Path: "/user/" + id}
+		raw = append(raw, r.Bytes()...)
+	}
+	if err := c.WriteRaw(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		res, err := c.ReadResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Body) != id {
+			t.Errorf("responses out of order: expected %q, got %q", id, res.Body)
+		}
+	}
+}
+
+func TestServer_SideBySide(t *testing.T) {
+	public := srv.New()
+	public.Get("/", func(c *srv.Context) { c.SendDirect(200, []byte("public")) })
+	admin := srv.New()
+	admin.Get("/", func(c *srv.Context) { c.SendDirect(200, []byte("admin")) })
+
+	servers := []*Server{Start(t, public), Start(t, admin)}
+	names := []string{"public", "admin"}
+
+	done := make(chan error, len(servers))
+	for i, ts := range servers {
+		go func() {
+			c, err := ts.Dial()
+			if err != nil {
+				done <- err
+				return
+			}
+			defer c.Close()
+
+			for range 50 {
+				res, err := c.Do(Request{Path: "/"})
+				if err != nil {
+					done <- err
+					return
+				}
+				if string(res.Body) != names[i] || res.Header.Get("Date") == "" {
+					t.Errorf("unexpected response from %s: %q %v", names[i], res.Body, res.Header)
+				}
+			}
+			done <- nil
+		}()
+	}
+
+	for range servers {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// logger that records messages for assertions
+type recLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recLogger) Enabled(lvl engine.Level) bool { return lvl >= engine.LevelInfo }
+
+func (l *recLogger) Log(lvl engine.Level, msg string, fields ...engine.Field) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, msg)
+	l.mu.Unlock()
+}
+
+func (l *recLogger) has(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServer_Logger(t *testing.T) {
+	log := &recLogger{}
+	s := srv.NewWithConfig(srv.Config{Logger: log})
+	ts := Start(t, s)
+
+	if !log.has("engine started") {
+		t.Error("startup was not logged")
+	}
+
+	c := ts.PipeClient(t)
+	if err := c.WriteRaw([]byte("GET / HTTP/1.1\nHost: x\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// server drops the conn after rejecting request
+	c.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.Conn().Read(make([]byte, 64))
+
+	if !log.has("request rejected") {
+		t.Errorf("parse error was not logged: %v", log.msgs)
+	}
+
+	ts.Close()
+	if !log.has("server is down") {
+		t.Error("shutdown was not logged")
+	}
+}
+
+func TestServer_LargeBody(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 1<<18) // 4MB, bigger than session buffers
+
+	s := srv.New()
+	s.Get("/big", func(c *srv.Context) { c.SendDirect(200, big) })
+	ts := Start(t, s)
+	c := ts.PipeClient(t)
+
+	for range 2 {
+		res, err := c.Do(Request{Path: "/big"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res.Body, big) {
+			t.Fatalf("body mismatch: got %d bytes, expected %d", len(res.Body), len(big))
+		}
+	}
+}
+
+func TestServer_ErrorResponses(t *testing.T) {
+	s := srv.NewWithConfig(srv.Config{Limits: srv.Limits{MaxURI: 128, MaxBody: 1024}})
+	s.Get("/", func(c *srv.Context) { c.SendDirect(200, nil) })
+	ts := Start(t, s)
+
+	manyHeaders := ""
+	for i := range 20 {
+		manyHeaders += "X-H" + strings.Repeat("x", i) + ": v\r\n"
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		code int
+	}{
+		{"Bad Request", "GET / HTTP/1.1\nHost: x\r\n\r\n", 400},
+		{"URI Too Long", "GET /" + strings.Repeat("a", 200) + " HTTP/1.1\r\n\r\n", 414},
+		{"Headers Too Large", "GET / HTTP/1.1\r\n" + manyHeaders + "\r\n", 431},
+		{"Payload Too Large", "POST / HTTP/1.1\r\nContent-Length: 4096\r\n\r\n", 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ts.PipeClient(t)
+			if err := c.WriteRaw([]byte(tt.raw)); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := c.ReadResponse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.code || !res.Close {
+				t.Errorf("expected %d with Connection: close, got %d %v", tt.code, res.Code, res.Header)
+			}
+
+			// server closes conn after error response
+			if n, err := c.br.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("expected EOF, got %d %v", n, err)
+			}
+		})
+	}
+}
+
+func TestServer_SSE(t *testing.T) {
+	s := srv.New()
+	streams := make(chan *srv.Stream, 1)
+	s.Get("/events", func(c *srv.Context) {
+		st, err := c.SSE()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		streams <- st
+	})
+	ts := Start(t, s)
+	c := ts.Client(t)
+
+	// status line and header block, body has no length and lasts until close
+	c.Send(Request{Path: "/events"})
+	c.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head []string
+	for {
+		line, err := c.Reader().ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		head = append(head, strings.TrimSpace(line))
+	}
+	if head[0] != "HTTP/1.1 200 OK" || !slices.Contains(head, "Content-Type: text/event-stream") {
+		t.Fatalf("bad stream header %q", head)
+	}
+	for _, h := range head {
+		if strings.HasPrefix(h, "Content-Length") {
+			t.Fatalf("stream must not have %q", h)
+		}
+	}
+
+	st := <-streams
+	// line breaks would inject fields
+	if st.ID("7\ndata: x") == nil || st.Event("a\r\nretry: 1", nil) == nil || st.Comment("\nid: 1") == nil {
+		t.Error("expected multi-line id, event name and comment to be rejected")
+	}
+	go func() {
+		st.ID("7")
+		st.Retry(3 * time.Second)
+		st.Event("update", []byte("a\nb"))
+		st.Comment("hi")
+		st.Data([]byte("plain"))
+		st.Data([]byte("c\rid: x\r\nd")) // lone CR ends line for client too
+	}()
+
+	want := "id: 7\nretry: 3000\nevent: update\ndata: a\ndata: b\n\n: hi\n\ndata: plain\n\n" +
+		"data: c\ndata: id: x\ndata: d\n\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c.Reader(), got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// client data is ignored, disconnect is noticed
+	c.WriteRaw([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.Close()
+	select {
+	case <-st.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect is not noticed")
+	}
+	if err := st.Data([]byte("late")); err == nil {
+		t.Error("expected error after disconnect")
+	}
+}
+
+func TestServer_SSEHeartbeat(t *testing.T) {
+	s := srv.New()
+	s.Get("/events", func(c *srv.Context) {
+		st, _ := c.SSE()
+		st.Heartbeat(10 * time.Millisecond)
+		time.AfterFunc(100*time.Millisecond, st.Close)
+	})
+	ts := Start(t, s)
+	c := ts.Client(t)
+
+	c.Send(Request{Path: "/events"})
+	c.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	body, err := io.ReadAll(c.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stream ends with conn close after Close
+	if n := strings.Count(string(body), ":\n\n"); n < 2 {
+		t.Errorf("expected heartbeats, got %q", body)
+	}
+}
+
+func TestServer_KeepAlive(t *testing.T) {
+	s := srv.New()
+	s.Get("/ping", func(c *srv.Context) {
+		c.SendDirect(200, []byte("pong"))
+	})
+	s.Get("/bye", func(c *srv.Context) {
+		c.CloseConn()
+		c.SendDirect(200, []byte("bye"))
+	})
+	ts := Start(t, s)
+
+	tests := []struct {
+		name  string
+		reqs  string
+		conn  string // Connection header of response
+		ka    string // Keep-Alive header
+		close bool   // conn is closed after first response
+	}{
+		{"HTTP/1.1 Default", "GET /ping HTTP/1.1\r\nHost: x\r\n\r\n", "", "", false},
+		{"HTTP/1.1 Close", "GET /ping HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\nGET /ping HTTP/1.1\r\n\r\n", "close", "", true},
+		{"HTTP/1.1 Close Token", "GET /ping HTTP/1.1\r\nConnection: TE, Close\r\n\r\n", "close", "", true},
+		{"HTTP/1.0 Default", "GET /ping HTTP/1.0\r\n\r\n", "close", "", true},
+		{"HTTP/1.0 Keep-Alive", "GET /ping HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", "keep-alive", "timeout=20", false},
+		{"Handler Close", "GET /bye HTTP/1.1\r\n\r\nGET /ping HTTP/1.1\r\n\r\n", "close", "", true},
+		{"Not Found Keeps Conn", "GET /missing HTTP/1.1\r\n\r\n", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ts.Client(t)
+			c.WriteRaw([]byte(tt.reqs))
+
+			res, err := c.ReadResponse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Header.Get("Keep-Alive"); got != tt.ka {
+				t.Errorf("expected Keep-Alive %q, got %q", tt.ka, got)
+			}
+			// net/http hides Connection header, it is reflected in Close
+			if res.Close != (tt.conn == "close") {
+				t.Errorf("expected Connection %q, got close=%v", tt.conn, res.Close)
+			}
+
+			if tt.close {
+				// pipelined request after close is not served, server closes conn
+				if _, err := c.Reader().ReadByte(); err != io.EOF {
+					t.Errorf("expected EOF, got %v", err)
+				}
+				return
+			}
+			if res, err := c.Do(Request{Path: "/ping"}); err != nil || string(res.Body) != "pong" {
+				t.Errorf("conn is not reusable: %v", err)
+			}
+		})
+	}
+}
+
+func TestServer_ChunkedBody(t *testing.T) {
+	s := srv.New()
+	s.Post("/echo", func(c *srv.Context) {
+		c.SetHeader([]byte("X-Sum"), c.Trailer([]byte("x-sum")))
+		c.SendDirect(200, c.Body())
+	})
+	ts := Start(t, s)
+	c := ts.PipeClient(t)
+
+	// body comes in pieces, then keep-alive request after it
+	parts := []string{
+		"POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		"lo\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: 12\r",
+		"\n\r\n",
+	}
+	for _, p := range parts {
+		if err := c.WriteRaw([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != 200 || string(res.Body) != "hello, world" || res.Header.Get("X-Sum") != "12" {
+		t.Fatalf("got %d %q %v", res.Code, res.Body, res.Header)
+	}
+
+	res, err = c.Do(Request{Method: "POST", Path: "/echo", Body: []byte("plain")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != "plain" {
+		t.Errorf("next request on conn: got %q", res.Body)
+	}
+}
+
+func TestServer_ChunkedResponse(t *testing.T) {
+	big := bytes.Repeat([]byte("z"), 100<<10)
+
+	s := srv.New()
+	s.Get("/stream", func(c *srv.Context) {
+		c.SetHeader([]byte("Trailer"), []byte("X-Count"))
+		w, err := c.Chunked(200)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.WriteString("hello ")
+		w.Flush()
+		w.WriteString("world ")
+		w.Write(big)
+		w.SetTrailer([]byte("X-Count"), []byte("3"))
+		// handler returns without Close, server finishes response
+	})
+	s.Get("/empty", func(c *srv.Context) {
+		w, _ := c.Chunked(204)
+		w.Close()
+		if _, err := w.Write([]byte("x")); err != srv.ErrWriterClosed {
+			t.Errorf("expected ErrWriterClosed, got %v", err)
+		}
+	})
+	s.Get("/ping", func(c *srv.Context) { c.SendDirect(200, []byte("pong")) })
+	ts := Start(t, s)
+	c := ts.PipeClient(t)
+
+	// pipelined: responses before and after stream keep their order
+	for _, p := range []string{"/ping", "/stream", "/ping"} {
+		c.Send(Request{Path: p})
+	}
+	want := []string{"pong", "hello world " + string(big), "pong"}
+	for i, w := range want {
+		res, err := c.ReadResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Body) != w || res.Close {
+			t.Fatalf("%d: got %d %d bytes, close %v", i, res.Code, len(res.Body), res.Close)
+		}
+		if i == 1 && (res.Header.Get("Transfer-Encoding") != "" || res.Trailer.Get("X-Count") != "3") {
+			t.Errorf("bad chunked response %v %v", res.Header, res.Trailer)
+		}
+	}
+
+	res, err := c.Do(Request{Path: "/empty"})
+	if err != nil || res.Code != 204 || len(res.Body) != 0 {
+		t.Fatalf("empty: %v %v", res, err)
+	}
+
+	// http/1.0 gets body delimited by close
+	c10 := ts.PipeClient(t)
+	c10.WriteRaw([]byte("GET /stream HTTP/1.0\r\n\r\n"))
+	res, err = c10.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Close || string(res.Body) != "hello world "+string(big) {
+		t.Errorf("http/1.0: close %v, %d bytes", res.Close, len(res.Body))
+	}
+}
+
+func TestServer_ExpectContinue(t *testing.T) {
+	s := srv.New()
+	s.Post("/upload", func(c *srv.Context) { c.SendDirect(200, c.Body()) })
+	s.Expect("POST", "/upload", func(c *srv.Context) int {
+		if len(c.Header([]byte("Authorization"))) == 0 {
+			return 401
+		}
+		return 100
+	})
+	s.Post("/open", func(c *srv.Context) { c.SendDirect(200, c.Body()) })
+	ts := Start(t, s)
+
+	const interim = "HTTP/1.1 100 Continue\r\n\r\n"
+	readInterim := func(c *Client) {
+		t.Helper()
+		b := make([]byte, len(interim))
+		if _, err := io.ReadFull(c.Reader(), b); err != nil || string(b) != interim {
+			t.Fatalf("expected 100 Continue, got %q %v", b, err)
+		}
+	}
+
+	for _, tc := range []struct{ path, auth string }{{"/upload", "Authorization: x\r\n"}, {"/open", ""}} {
+		c := ts.PipeClient(t)
+		c.WriteRaw([]byte("POST " + tc.path + " HTTP/1.1\r\n" + tc.auth + "Expect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+		readInterim(c)
+		c.WriteRaw([]byte("data"))
+		res, err := c.ReadResponse()
+		if err != nil || res.Code != 200 || string(res.Body) != "data" {
+			t.Fatalf("%s: %v %v", tc.path, res, err)
+		}
+	}
+
+	// rejected by hook and unknown expectation: final status, body is never read
+	for raw, code := range map[string]int{
+		"POST /upload HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n": 401,
+		"POST /open HTTP/1.1\r\nExpect: something\r\nContent-Length: 4\r\n\r\n":      417,
+	} {
+		c := ts.PipeClient(t)
+		c.WriteRaw([]byte(raw))
+		res, err := c.ReadResponse()
+		if err != nil || res.Code != code || !res.Close {
+			t.Errorf("expected %d with close, got %v %v", code, res, err)
+		}
+	}
+
+	// body is already here, no interim response
+	c := ts.PipeClient(t)
+	res, err := c.Do(Request{Method: "POST", Path: "/open", Header: [][2]string{{"Expect", "100-continue"}}, Body: []byte("now")})
+	if err != nil || res.Code != 200 || string(res.Body) != "now" {
+		t.Fatalf("%v %v", res, err)
+	}
+}
+
+func TestServer_Forms(t *testing.T) {
+	// temp file name goes from handler goroutine to test
+	spilled := make(chan string, 1)
+	s := srv.New()
+	s.Post("/form", func(c *srv.Context) {
+		var b1, b2 [64]byte
+		c.SendDirect(200, []byte(string(c.FormValue([]byte("name"), b1[:]))+"|"+string(c.FormValue([]byte("tag"), b2[:]))))
+	})
+	s.Post("/upload", func(c *srv.Context) {
+		if _, err := c.MultipartForm(16); err != nil {
+			c.SendDirect(400, []byte(err.Error()))
+			return
+		}
+		small, err := c.FormFile([]byte("small"))
+		if err != nil {
+			c.SendDirect(400, []byte(err.Error()))
+			return
+		}
+		big, _ := c.FormFile([]byte("big"))
+		if big.Value() != nil {
+			t.Error("big file is expected on disk")
+		}
+		r, _ := big.Open()
+		if f, ok := r.(interface{ Name() string }); ok {
+			spilled <- f.Name()
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+
+		var buf [64]byte
+		out := string(c.FormValue([]byte("title"), buf[:])) + "|" + string(small.Filename) + ":" + string(small.Value()) +
+			"|" + string(small.ContentType()) + "|" + string(big.Filename) + ":" + string(data)
+		c.SendDirect(200, []byte(out))
+	})
+	ts := Start(t, s)
+	c := ts.PipeClient(t)
+
+	res, err := c.Do(Request{Method: "POST", Path: "/form",
+		Header: [][2]string{{"Content-Type", "application/x-www-form-urlencoded"}},
+		Body:   []byte("name=John+Doe&tag=a%26b&tag=c")})
+	if err != nil || string(res.Body) != "John Doe|a&b" {
+		t.Fatalf("urlencoded: %v %v", res, err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "hello")
+	fw, _ := mw.CreateFormFile("small", `a;"b".txt`)
+	fw.Write([]byte("tiny"))
+	fw, _ = mw.CreateFormFile("big", "b.bin")
+	fw.Write(bytes.Repeat([]byte("B"), 100))
+	mw.Close()
+
+	res, err = c.Do(Request{Method: "POST", Path: "/upload",
+		Header: [][2]string{{"Content-Type", mw.FormDataContentType()}},
+		Body:   body.Bytes()})
+	want := `hello|a;"b".txt:tiny|application/octet-stream|b.bin:` + strings.Repeat("B", 100)
+	if err != nil || res.Code != 200 || string(res.Body) != want {
+		t.Fatalf("multipart: %v %v", res, err)
+	}
+	select {
+	case name := <-spilled:
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("temp file %s is not removed", name)
+		}
+	default:
+		t.Fatal("big part was not spilled")
+	}
+
+	res, err = c.Do(Request{Method: "POST", Path: "/upload",
+		Header: [][2]string{{"Content-Type", "multipart/form-data; boundary=xyz"}},
+		Body:   []byte("--xyz\r\nbroken")})
+	if err != nil || res.Code != 400 {
+		t.Errorf("broken multipart: %v %v", res, err)
+	}
+}
+
+func TestServer_Validation(t *testing.T) {
+	type createUser struct {
+		Org   string `path:"org" validate:"oneof=acme corp"`
+		Name  string `json:"name" validate:"required,max=16"`
+		Email string `json:"email" validate:"required,email"`
+		Age   int    `json:"age" validate:"min=18"`
+		Dry   bool   `query:"dry"`
+	}
+	s := srv.New()
+	s.Post("/orgs/:org/users", srv.Bound(func(c *srv.Context, u *createUser) {
+		c.SendDirect(201, []byte(u.Org+"|"+u.Name+"|"+u.Email+"|"+strconv.FormatBool(u.Dry)))
+	}))
+	ts := Start(t, s)
+	c := ts.PipeClient(t)
+	jsonCT := [][2]string{{"Content-Type", "application/json"}}
+
+	res, err := c.Do(Request{Method: "POST", Path: "/orgs/acme/users?dry", Header: jsonCT,
+		Body: []byte(`{"name":"John","email":"john@example.com","age":30}`)})
+	if err != nil || res.Code != 201 || string(res.Body) != "acme|John|john@example.com|true" {
+		t.Fatalf("valid: %v %v", res, err)
+	}
+
+	res, err = c.Do(Request{Method: "POST", Path: "/orgs/evil/users", Header: jsonCT,
+		Body: []byte(`{"email":"nope","age":12}`)})
+	if err != nil || res.Code != 422 || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("invalid: %v %v", res, err)
+	}
+	var body struct {
+		Violations []srv.Violation `json:"violations"`
+	}
+	if err := json.Unmarshal(res.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range body.Violations {
+		got = append(got, v.Field+":"+v.Rule)
+	}
+	if strings.Join(got, " ") != "org:oneof name:required email:email age:min" {
+		t.Errorf("violations: %s", res.Body)
+	}
+
+	// malformed value never reaches validation
+	res, err = c.Do(Request{Method: "POST", Path: "/orgs/acme/users?dry=maybe", Header: jsonCT, Body: []byte(`{"age":"old"}`)})
+	if err != nil || res.Code != 400 || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("malformed: %v %v", res, err)
+	}
+	var bad struct {
+		Errors []struct {
+			Field, Source, Key, Message string
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(res.Body, &bad); err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, e := range bad.Errors {
+		got = append(got, e.Source+":"+e.Key)
+		if e.Field == "" || e.Message == "" {
+			t.Errorf("field error without field or message: %s", res.Body)
+		}
+	}
+	if strings.Join(got, " ") != "json:age query:dry" {
+		t.Errorf("field errors: %s", res.Body)
+	}
+}
+
+func TestServer_SlowReaderDoesNotStallWorker(t *testing.T) {
+	// one worker, so both conns are served by it
+	s := srv.NewWithConfig(srv.Config{PinCPU: true, CPUs: []int{firstCPU(t)}})
+	big := bytes.Repeat([]byte("x"), 3<<20) // way over socket buffers
+	s.Get("/big", func(c *srv.Context) { c.SendDirect(200, big) })
+	s.Get("/small", func(c *srv.Context) { c.SendDirect(200, []byte("ok")) })
+	ts := Start(t, s)
+
+	slow := ts.PipeClient(t)
+	if err := slow.Send(Request{Method: "GET", Path: "/big"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let worker hit full socket
+
+	fast := ts.PipeClient(t)
+	fast.Timeout = time.Second
+	start := time.Now()
+	res, err := fast.Do(Request{Method: "GET", Path: "/small"})
+	if err != nil || string(res.Body) != "ok" {
+		t.Fatalf("fast client: %v %v", res, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("fast client waited %v behind slow reader", d)
+	}
+
+	// rest of big body is sent from event loop as slow client reads it
+	res, err = slow.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Body, big) {
+		t.Fatalf("slow client: got %d bytes", len(res.Body))
+	}
+	if res, err := slow.Do(Request{Method: "GET", Path: "/small"}); err != nil || string(res.Body) != "ok" {
+		t.Fatalf("conn after drain: %v %v", res, err)
+	}
+}
+
+func TestServer_SlowReaderChunked(t *testing.T) {
+	s := srv.NewWithConfig(srv.Config{PinCPU: true, CPUs: []int{firstCPU(t)}})
+	piece := bytes.Repeat([]byte("y"), 64<<10)
+	s.Get("/stream", func(c *srv.Context) {
+		w, _ := c.Chunked(200)
+		for range 32 { // 2MB, client doesn't read while it is written
+			if _, err := w.Write(piece); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	s.Get("/small", func(c *srv.Context) { c.SendDirect(200, []byte("ok")) })
+	ts := Start(t, s)
+
+	slow := ts.PipeClient(t)
+	slow.Send(Request{Method: "GET", Path: "/stream"})
+	time.Sleep(50 * time.Millisecond)
+
+	fast := ts.PipeClient(t)
+	start := time.Now()
+	if res, err := fast.Do(Request{Method: "GET", Path: "/small"}); err != nil || string(res.Body) != "ok" {
+		t.Fatalf("fast client: %v %v", res, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("fast client waited %v behind streaming writer", d)
+	}
+
+	res, err := slow.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Body) != 32*len(piece) {
+		t.Fatalf("slow client: got %d bytes", len(res.Body))
+	}
+}
+
+// first cpu process may run on
+func firstCPU(t *testing.T) int {
+	var set [16]uint64
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set))); errno != 0 {
+		t.Skip("sched_getaffinity:", errno)
+	}
+	for i := range len(set) * 64 {
+		if set[i/64]&(1<<(i%64)) != 0 {
+			return i
+		}
+	}
+	t.Skip("no cpus")
+	return 0
+}
@@ -0,0 +1,845 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+const (
+	// streams client can have open at once (our SETTINGS_MAX_CONCURRENT_STREAMS)
+	maxStreams = 100
+	// header block bigger than this is not accepted (it wouldn't fit stream buffer anyway)
+	maxHeaderBlock = 1 << 16
+	// write out buffer when it grows over this
+	flushSize = 1 << 16
+)
+
+var (
+	clientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	protoH2       = []byte("HTTP/2.0")
+	hostKey       = []byte("Host")
+	serverName    = []byte("goserver")
+
+	errResponded = errors.New("http2: response already sent")
+)
+
+var errHeaders = []engine.Header{
+	{Key: []byte("Content-Type"), Val: []byte("text/plain")},
+}
+
+// connection error, conn is closed with GOAWAY
+type connError struct {
+	code uint32
+	msg  string
+}
+
+func (e *connError) Error() string { return "http2: " + e.msg }
+
+func protoErr(msg string) error { return &connError{code: codeProtocol, msg: msg} }
+
+// http2 conn over hijacked session, everything runs on session worker
+type Conn struct {
+	s       *engine.Session
+	handler Handler
+
+	preface  bool // client preface received
+	settings bool // first client SETTINGS received
+
+	out  []byte // frames of current pass, written at once
+	hbuf []byte // scratch for response header block
+
+	dec         decoder
+	block       []byte // header block from HEADERS + CONTINUATION
+	blockStream uint32 // stream of block in progress, 0 if none
+	blockFlags  uint8  // flags of HEADERS that started block
+
+	streams map[uint32]*stream
+	lastID  uint32
+
+	// peer settings and conn level send window
+	maxFrame   int
+	initWindow int64
+	window     int64
+}
+
+func newConn(s *engine.Session, h Handler) *Conn {
+	c := &Conn{
+		s:          s,
+		handler:    h,
+		dec:        newDecoder(),
+		streams:    make(map[uint32]*stream),
+		maxFrame:   defaultMaxFrame,
+		initWindow: defaultWindow,
+		window:     defaultWindow,
+	}
+
+	// server preface is just SETTINGS, defaults are fine except stream limit
+	c.out = appendFrameHeader(c.out, 6, frameSettings, 0, 0)
+	c.out = binary.BigEndian.AppendUint16(c.out, settingMaxConcurrentStreams)
+	c.out = binary.BigEndian.AppendUint32(c.out, maxStreams)
+	return c
+}
+
+// take over session reads
+func (c *Conn) attach() {
+	c.s.Hijack(c.serve)
+	c.s.OnClose(c.onClose)
+}
+
+// read handler: check preface, then handle every complete frame in buffer
+func (c *Conn) serve(s *engine.Session) (bool, error) {
+	buf := s.Buf[:s.Offset]
+	pos := 0
+
+	var err error
+	if !c.preface {
+		switch {
+		case len(buf) < len(clientPreface) && bytes.HasPrefix(clientPreface, buf):
+			return false, c.flush()
+		case !bytes.HasPrefix(buf, clientPreface):
+			err = protoErr("bad client preface")
+		default:
+			pos = len(clientPreface)
+			c.preface = true
+		}
+	}
+
+	for err == nil && len(buf)-pos >= frameHeaderLen {
+		h := parseFrameHeader(buf[pos:])
+		if h.length > defaultMaxFrame {
+			err = &connError{code: codeFrameSize, msg: "frame is bigger than SETTINGS_MAX_FRAME_SIZE"}
+			break
+		}
+		end := pos + frameHeaderLen + h.length
+		if end > len(buf) {
+			break
+		}
+
+		err = c.frame(h, buf[pos+frameHeaderLen:end])
+		pos = end
+		if len(c.out) > flushSize && err == nil {
+			err = c.flush()
+		}
+	}
+
+	if pos > 0 {
+		copy(s.Buf, s.Buf[pos:s.Offset])
+		s.Offset -= uint32(pos)
+	}
+
+	if err != nil {
+		var ce *connError
+		if errors.As(err, &ce) {
+			c.out = appendFrameHeader(c.out, 8, frameGoAway, 0, 0)
+			c.out = binary.BigEndian.AppendUint32(c.out, c.lastID)
+			c.out = binary.BigEndian.AppendUint32(c.out, ce.code)
+		}
+		c.flush()
+		return false, err
+	}
+	return s.Offset == 0, c.flush()
+}
+
+func (c *Conn) frame(h frameHeader, p []byte) error {
+	// header block must be continued right away, nothing can come in between
+	if c.blockStream != 0 && (h.typ != frameContinuation || h.stream != c.blockStream) {
+		return protoErr("expected CONTINUATION")
+	}
+	if !c.settings && (h.typ != frameSettings || h.flags&flagAck != 0) {
+		return protoErr("first frame must be SETTINGS")
+	}
+
+	switch h.typ {
+	case frameSettings:
+		return c.onSettings(h, p)
+	case frameHeaders:
+		return c.onHeaders(h, p)
+	case frameContinuation:
+		if c.blockStream == 0 {
+			return protoErr("unexpected CONTINUATION")
+		}
+		if len(c.block)+len(p) > maxHeaderBlock {
+			return protoErr("header block is too big")
+		}
+		c.block = append(c.block, p...)
+		if h.flags&flagEndHeaders != 0 {
+			return c.endHeaders()
+		}
+		return nil
+	case frameData:
+		return c.onData(h, p)
+	case frameWindowUpdate:
+		return c.onWindowUpdate(h, p)
+
+	case framePing:
+		if h.stream != 0 {
+			return protoErr("PING on stream")
+		}
+		if len(p) != 8 {
+			return &connError{code: codeFrameSize, msg: "bad PING length"}
+		}
+		if h.flags&flagAck == 0 {
+			c.out = appendFrameHeader(c.out, 8, framePing, flagAck, 0)
+			c.out = append(c.out, p...)
+		}
+	case frameRSTStream:
+		if h.stream == 0 || h.stream > c.lastID {
+			return protoErr("RST_STREAM on idle stream")
+		}
+		if len(p) != 4 {
+			return &connError{code: codeFrameSize, msg: "bad RST_STREAM length"}
+		}
+		if st := c.streams[h.stream]; st != nil {
+			c.closeStream(st)
+		}
+	case framePriority:
+		if h.stream == 0 {
+			return protoErr("PRIORITY on conn")
+		}
+		if len(p) != 5 {
+			c.reset(h.stream, codeFrameSize)
+		}
+	case frameGoAway:
+		if h.stream != 0 {
+			return protoErr("GOAWAY on stream")
+		}
+	case framePushPromise:
+		return protoErr("PUSH_PROMISE from client")
+	}
+	// unknown types are ignored
+	return nil
+}
+
+func (c *Conn) onSettings(h frameHeader, p []byte) error {
+	if h.stream != 0 {
+		return protoErr("SETTINGS on stream")
+	}
+	if h.flags&flagAck != 0 {
+		if len(p) != 0 {
+			return &connError{code: codeFrameSize, msg: "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+	if len(p)%6 != 0 {
+		return &connError{code: codeFrameSize, msg: "bad SETTINGS length"}
+	}
+
+	c.settings = true
+	if err := c.applySettings(p); err != nil {
+		return err
+	}
+	c.out = appendFrameHeader(c.out, 0, frameSettings, flagAck, 0)
+	return nil
+}
+
+// apply peer settings (from SETTINGS frame or HTTP2-Settings header)
+func (c *Conn) applySettings(p []byte) error {
+	for ; len(p) >= 6; p = p[6:] {
+		v := binary.BigEndian.Uint32(p[2:])
+		switch binary.BigEndian.Uint16(p) {
+		case settingEnablePush:
+			if v > 1 {
+				return protoErr("bad SETTINGS_ENABLE_PUSH")
+			}
+		case settingInitialWindowSize:
+			if v > maxWindow {
+				return &connError{code: codeFlowControl, msg: "bad SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			// change applies to all open streams
+			delta := int64(v) - c.initWindow
+			c.initWindow = int64(v)
+			for _, st := range c.streams {
+				st.window += delta
+				if st.window > maxWindow {
+					return &connError{code: codeFlowControl, msg: "stream window overflow"}
+				}
+			}
+		case settingMaxFrameSize:
+			if v < defaultMaxFrame || v > maxFrameLimit {
+				return protoErr("bad SETTINGS_MAX_FRAME_SIZE")
+			}
+			c.maxFrame = int(v)
+		}
+	}
+	c.drain()
+	return nil
+}
+
+func (c *Conn) onWindowUpdate(h frameHeader, p []byte) error {
+	if len(p) != 4 {
+		return &connError{code: codeFrameSize, msg: "bad WINDOW_UPDATE length"}
+	}
+	inc := int64(binary.BigEndian.Uint32(p) & maxWindow)
+
+	if h.stream == 0 {
+		if inc == 0 {
+			return protoErr("zero WINDOW_UPDATE")
+		}
+		c.window += inc
+		if c.window > maxWindow {
+			return &connError{code: codeFlowControl, msg: "conn window overflow"}
+		}
+		c.drain()
+		return nil
+	}
+
+	st := c.streams[h.stream]
+	if st == nil {
+		if h.stream > c.lastID {
+			return protoErr("WINDOW_UPDATE on idle stream")
+		}
+		return nil
+	}
+	if inc == 0 {
+		c.reset(st.id, codeProtocol)
+		c.closeStream(st)
+		return nil
+	}
+	st.window += inc
+	if st.window > maxWindow {
+		c.reset(st.id, codeFlowControl)
+		c.closeStream(st)
+		return nil
+	}
+	c.drain()
+	return nil
+}
+
+func (c *Conn) onHeaders(h frameHeader, p []byte) error {
+	if h.stream == 0 || h.stream%2 == 0 {
+		return protoErr("bad stream id for HEADERS")
+	}
+	p, ok := unpad(h, p)
+	if !ok {
+		return protoErr("bad padding")
+	}
+	if h.flags&flagPriority != 0 {
+		if len(p) < 5 {
+			return protoErr("bad HEADERS priority")
+		}
+		p = p[5:]
+	}
+
+	c.block = append(c.block[:0], p...)
+	c.blockStream, c.blockFlags = h.stream, h.flags
+	if h.flags&flagEndHeaders != 0 {
+		return c.endHeaders()
+	}
+	return nil
+}
+
+// header block is complete: open stream (or take trailers) and decode it
+func (c *Conn) endHeaders() error {
+	id, flags := c.blockStream, c.blockFlags
+	c.blockStream = 0
+
+	st := c.streams[id]
+	switch {
+	case st != nil: // trailers, they are dropped
+		if err := c.discardBlock(); err != nil {
+			return err
+		}
+		if st.recvEnd || st.sub == nil {
+			c.reset(id, codeStreamClosed)
+			c.closeStream(st)
+			return nil
+		}
+		if flags&flagEndStream == 0 || !st.lengthOK() {
+			c.reset(id, codeProtocol)
+			c.closeStream(st)
+			return nil
+		}
+		st.recvEnd = true
+		c.dispatch(st)
+		return nil
+
+	case id <= c.lastID:
+		c.discardBlock()
+		return &connError{code: codeStreamClosed, msg: "HEADERS on closed stream"}
+	}
+
+	c.lastID = id
+	if len(c.streams) >= maxStreams {
+		if err := c.discardBlock(); err != nil {
+			return err
+		}
+		c.reset(id, codeRefusedStream)
+		return nil
+	}
+
+	st = c.newStream(id)
+	if err := c.dec.decode(c.block, st.field); err != nil {
+		return &connError{code: codeCompression, msg: err.Error()}
+	}
+	if !st.endHeaders() {
+		c.reset(id, codeProtocol)
+		c.closeStream(st)
+		return nil
+	}
+
+	if flags&flagEndStream != 0 {
+		st.recvEnd = true
+		if !st.lengthOK() {
+			c.reset(id, codeProtocol)
+			c.closeStream(st)
+			return nil
+		}
+	}
+	// too big headers are answered right away
+	if st.recvEnd || st.overflow != 0 {
+		c.dispatch(st)
+	}
+	return nil
+}
+
+// decode block only to keep hpack table in sync
+func (c *Conn) discardBlock() error {
+	if err := c.dec.decode(c.block, func(name, value []byte) {}); err != nil {
+		return &connError{code: codeCompression, msg: err.Error()}
+	}
+	return nil
+}
+
+func (c *Conn) onData(h frameHeader, p []byte) error {
+	if h.stream == 0 {
+		return protoErr("DATA on conn")
+	}
+	// conn window is given back right away: data is either dropped or kept within stream window
+	if h.length > 0 {
+		c.windowUpdate(0, h.length)
+	}
+
+	st := c.streams[h.stream]
+	if st == nil {
+		if h.stream > c.lastID {
+			return protoErr("DATA on idle stream")
+		}
+		return nil // stream was reset by us, late frames are ignored
+	}
+	if st.recvEnd {
+		c.reset(st.id, codeStreamClosed)
+		c.closeStream(st)
+		return nil
+	}
+
+	p, ok := unpad(h, p)
+	if !ok {
+		return protoErr("bad padding")
+	}
+	st.recvWindow -= int64(h.length)
+	if st.recvWindow < 0 {
+		c.reset(st.id, codeFlowControl)
+		c.closeStream(st)
+		return nil
+	}
+	end := h.flags&flagEndStream != 0
+	if end {
+		st.recvEnd = true
+	}
+
+	// request is already answered (body was too big), rest of body is dropped
+	if st.sub == nil {
+		c.finish(st)
+		return nil
+	}
+
+	// body that doesn't match content-length is malformed request (RFC 9113 8.1.1)
+	st.recvd += int64(len(p))
+	if st.clen >= 0 && (st.recvd > st.clen || end && st.recvd != st.clen) {
+		c.reset(st.id, codeProtocol)
+		c.closeStream(st)
+		return nil
+	}
+
+	sub := st.sub
+	if st.pos+len(p) > len(sub.Buf) {
+		st.overflow = 413
+		c.dispatch(st)
+		return nil
+	}
+	st.pos += copy(sub.Buf[st.pos:], p)
+	sub.Req.Body.End = uint16(st.pos)
+
+	if end {
+		c.dispatch(st)
+		return nil
+	}
+	// body stays in buffer until dispatch, so stream window is given back
+	// only as far as buffer has room; full buffer and no end means body is too big
+	free := int64(len(sub.Buf) - st.pos)
+	if free == 0 {
+		st.overflow = 413
+		c.dispatch(st)
+		return nil
+	}
+	if credit := min(int64(h.length), free-st.recvWindow); credit > 0 {
+		c.windowUpdate(st.id, int(credit))
+		st.recvWindow += credit
+	}
+	return nil
+}
+
+// run request of stream through handler and give its session back
+func (c *Conn) dispatch(st *stream) {
+	sub := st.sub
+	if st.overflow != 0 {
+		st.Respond(sub, int(st.overflow), errHeaders, protocol.StatusText(int(st.overflow)))
+	} else if c.handler != nil {
+		c.handler(sub)
+	}
+	if !st.responded {
+		st.Respond(sub, 200, nil, nil)
+	}
+
+	st.sub = nil
+	engine.ReleaseStream(sub)
+	c.finish(st)
+}
+
+// forget stream when its response is fully sent
+func (c *Conn) finish(st *stream) {
+	if !st.sent || st.sub != nil {
+		return
+	}
+	// client is still sending body we don't need
+	if !st.recvEnd {
+		c.reset(st.id, codeNo)
+	}
+	delete(c.streams, st.id)
+}
+
+func (c *Conn) closeStream(st *stream) {
+	if st.sub != nil {
+		engine.ReleaseStream(st.sub)
+		st.sub = nil
+	}
+	delete(c.streams, st.id)
+}
+
+func (c *Conn) newStream(id uint32) *stream {
+	st := &stream{c: c, id: id, window: c.initWindow, recvWindow: defaultWindow, clen: -1, sub: engine.NewStream(c.s)}
+	st.sub.Responder = st
+	st.sub.Req.Protocol = st.put(protoH2)
+	c.streams[id] = st
+	return st
+}
+
+// response data frames within flow control windows, returns what didn't fit
+func (c *Conn) sendData(st *stream, data []byte) []byte {
+	for len(data) > 0 {
+		n := min(int64(len(data)), int64(c.maxFrame), st.window, c.window)
+		if n <= 0 {
+			break
+		}
+
+		var flags uint8
+		if int(n) == len(data) {
+			flags = flagEndStream
+		}
+		c.out = appendFrameHeader(c.out, int(n), frameData, flags, st.id)
+		c.out = append(c.out, data[:n]...)
+		st.window -= n
+		c.window -= n
+		data = data[n:]
+
+		if len(c.out) > flushSize {
+			c.flush()
+		}
+	}
+	return data
+}
+
+// send pending response data after window grew
+func (c *Conn) drain() {
+	for _, st := range c.streams {
+		if len(st.pending) == 0 || c.window <= 0 {
+			continue
+		}
+		st.pending = c.sendData(st, st.pending)
+		if len(st.pending) == 0 {
+			st.pending = nil
+			st.sent = true
+			c.finish(st)
+		}
+	}
+}
+
+// response header block, split into HEADERS + CONTINUATION by peer frame size
+func (c *Conn) writeHeaders(id uint32, code int, headers []engine.Header, bodylen int, date []byte, end bool) {
+	blk := appendStatus(c.hbuf[:0], code)
+	blk = appendIndexedName(blk, idxServer, serverName)
+	if date != nil {
+		blk = appendIndexedName(blk, idxDate, date)
+	}
+	if code >= 200 && code != 204 && code != 304 {
+		var nb [20]byte
+		blk = appendIndexedName(blk, idxContentLength, strconv.AppendInt(nb[:0], int64(bodylen), 10))
+	}
+	for _, h := range headers {
+		if connSpecific(h.Key) {
+			continue
+		}
+		blk = appendField(blk, h.Key, h.Val)
+	}
+	c.hbuf = blk
+
+	typ := frameHeaders
+	for {
+		n := min(len(blk), c.maxFrame)
+
+		var flags uint8
+		if typ == frameHeaders && end {
+			flags |= flagEndStream
+		}
+		if n == len(blk) {
+			flags |= flagEndHeaders
+		}
+		c.out = appendFrameHeader(c.out, n, typ, flags, id)
+		c.out = append(c.out, blk[:n]...)
+
+		blk = blk[n:]
+		typ = frameContinuation
+		if len(blk) == 0 {
+			return
+		}
+	}
+}
+
+func (c *Conn) reset(id uint32, code uint32) {
+	c.out = appendFrameHeader(c.out, 4, frameRSTStream, 0, id)
+	c.out = binary.BigEndian.AppendUint32(c.out, code)
+}
+
+func (c *Conn) windowUpdate(id uint32, n int) {
+	c.out = appendFrameHeader(c.out, 4, frameWindowUpdate, 0, id)
+	c.out = binary.BigEndian.AppendUint32(c.out, uint32(n))
+}
+
+func (c *Conn) flush() error {
+	if len(c.out) == 0 {
+		return nil
+	}
+	_, err := c.s.WriteDirect(c.out, nil)
+	c.out = c.out[:0]
+	return err
+}
+
+// engine close hook, give back sessions of unfinished streams
+func (c *Conn) onClose(*engine.Session) {
+	for _, st := range c.streams {
+		c.closeStream(st)
+	}
+}
+
+// one request/response exchange
+type stream struct {
+	c   *Conn
+	id  uint32
+	sub *engine.Session // request session, nil after dispatch
+
+	window     int64  // send window
+	pending    []byte // response data waiting for window
+	recvWindow int64  // body client may still send, credited up to free buffer space
+	clen       int64  // content-length of request, -1 if there is none
+	recvd      int64  // DATA payload received, must match clen at END_STREAM
+
+	recvEnd   bool // END_STREAM received
+	responded bool // response header is sent
+	sent      bool // whole response is sent
+
+	// request building
+	pos      int    // write position in sub.Buf
+	overflow uint16 // 431 or 413 if request doesn't fit session buffer
+	bad      bool   // malformed header block
+	regular  bool   // regular header seen, pseudo headers must come before
+	scheme   bool
+}
+
+// stream is a Responder for its request session
+func (st *stream) Respond(s *engine.Session, code int, headers []engine.Header, body []byte) error {
+	if st.responded {
+		return errResponded
+	}
+	st.responded = true
+
+	c := st.c
+	c.writeHeaders(st.id, code, headers, len(body), s.Date(), len(body) == 0)
+	if len(body) == 0 {
+		st.sent = true
+		return nil
+	}
+
+	// body can be gone after handler returns, so what doesn't fit window is copied
+	if rest := c.sendData(st, body); len(rest) > 0 {
+		st.pending = append([]byte(nil), rest...)
+	} else {
+		st.sent = true
+	}
+	return nil
+}
+
+// decoded header field goes to request session like http/1 parser would put it
+func (st *stream) field(name, value []byte) {
+	if st.bad || st.overflow != 0 {
+		return
+	}
+	req := &st.sub.Req
+
+	if len(name) > 0 && name[0] == ':' {
+		if st.regular {
+			st.bad = true
+			return
+		}
+		var v *engine.View
+		switch string(name) {
+		case ":method":
+			v = &req.Method
+		case ":path":
+			v = &req.Path
+		case ":scheme":
+			st.scheme = true
+			return
+		case ":authority":
+			st.header(hostKey, value)
+			return
+		default:
+			st.bad = true
+			return
+		}
+		if v.End != 0 {
+			st.bad = true // duplicate
+			return
+		}
+		*v = st.put(value)
+		return
+	}
+
+	st.regular = true
+	if !lowerToken(name) || connSpecific(name) {
+		st.bad = true
+		return
+	}
+	if bytes.Equal(name, []byte("te")) && !bytes.Equal(value, []byte("trailers")) {
+		st.bad = true
+		return
+	}
+	if bytes.Equal(name, []byte("content-length")) {
+		n, ok := parseLength(value)
+		if !ok || st.clen >= 0 && st.clen != n {
+			st.bad = true
+			return
+		}
+		st.clen = n
+	}
+	st.header(name, value)
+}
+
+// all DATA is received and it matches content-length (if request has it)
+func (st *stream) lengthOK() bool {
+	return st.clen < 0 || st.recvd == st.clen
+}
+
+// content-length value: digits only, no more than 18 so it can't overflow
+func parseLength(v []byte) (int64, bool) {
+	if len(v) == 0 || len(v) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+func (st *stream) header(name, value []byte) {
+	s := st.sub
+	if int(s.Req.Hcount) >= len(s.Hbuf) {
+		st.overflow = 431
+		return
+	}
+	k := st.put(name)
+	v := st.put(value)
+	if st.overflow != 0 {
+		return
+	}
+	canonical(k.AsBuf(s))
+	s.Hbuf[s.Req.Hcount] = engine.HeaderView{Key: k, Val: v}
+	s.Req.Index(k.AsBuf(s), int(s.Req.Hcount))
+	s.Req.Hcount++
+}
+
+// copy into session buffer
+func (st *stream) put(b []byte) engine.View {
+	if st.pos+len(b) > len(st.sub.Buf) {
+		st.overflow = 431
+		return engine.View{}
+	}
+	v := engine.View{St: uint16(st.pos)}
+	st.pos += copy(st.sub.Buf[st.pos:], b)
+	v.End = uint16(st.pos)
+	return v
+}
+
+// check required pseudo headers, body goes after header bytes
+func (st *stream) endHeaders() bool {
+	req := &st.sub.Req
+	req.Body = engine.View{St: uint16(st.pos), End: uint16(st.pos)}
+	if st.overflow != 0 {
+		return true
+	}
+	if st.bad || !st.scheme || req.Method.End == 0 || req.Path.End <= req.Path.St {
+		return false
+	}
+	p := req.Path.AsBuf(st.sub)
+	return p[0] == '/' || string(p) == "*"
+}
+
+// http2 header names are lowercase tokens
+func lowerToken(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c >= 'A' && c <= 'Z' || c <= ' ' || c >= 0x7f || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// hop-by-hop headers are not allowed in http2
+func connSpecific(name []byte) bool {
+	for _, h := range hopHeaders {
+		if bytes.EqualFold(name, h) {
+			return true
+		}
+	}
+	return false
+}
+
+var hopHeaders = [][]byte{
+	[]byte("connection"),
+	[]byte("keep-alive"),
+	[]byte("proxy-connection"),
+	[]byte("transfer-encoding"),
+	[]byte("upgrade"),
+}
+
+// content-type -> Content-Type in place, so handlers see same names as over http/1
+func canonical(name []byte) {
+	up := true
+	for i, c := range name {
+		if up && c >= 'a' && c <= 'z' {
+			name[i] = c - ('a' - 'A')
+		}
+		up = c == '-'
+	}
+}
@@ -0,0 +1,105 @@
+package http2
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// frame types
+const (
+	frameData         uint8 = 0x0
+	frameHeaders      uint8 = 0x1
+	framePriority     uint8 = 0x2
+	frameRSTStream    uint8 = 0x3
+	frameSettings     uint8 = 0x4
+	framePushPromise  uint8 = 0x5
+	framePing         uint8 = 0x6
+	frameGoAway       uint8 = 0x7
+	frameWindowUpdate uint8 = 0x8
+	frameContinuation uint8 = 0x9
+)
+
+// frame flags
+const (
+	flagEndStream  uint8 = 0x1
+	flagAck        uint8 = 0x1
+	flagEndHeaders uint8 = 0x4
+	flagPadded     uint8 = 0x8
+	flagPriority   uint8 = 0x20
+)
+
+// settings ids
+const (
+	settingHeaderTableSize      uint16 = 0x1
+	settingEnablePush           uint16 = 0x2
+	settingMaxConcurrentStreams uint16 = 0x3
+	settingInitialWindowSize    uint16 = 0x4
+	settingMaxFrameSize         uint16 = 0x5
+	settingMaxHeaderListSize    uint16 = 0x6
+)
+
+// error codes for RST_STREAM and GOAWAY
+const (
+	codeNo            uint32 = 0x0
+	codeProtocol      uint32 = 0x1
+	codeInternal      uint32 = 0x2
+	codeFlowControl   uint32 = 0x3
+	codeStreamClosed  uint32 = 0x5
+	codeFrameSize     uint32 = 0x6
+	codeRefusedStream uint32 = 0x7
+	codeCancel        uint32 = 0x8
+	codeCompression   uint32 = 0x9
+)
+
+const (
+	frameHeaderLen  = 9
+	defaultWindow   = 65535
+	defaultMaxFrame = 16384
+	maxFrameLimit   = 1<<24 - 1
+	maxWindow       = 1<<31 - 1
+)
+
+type frameHeader struct {
+	length int
+	typ    uint8
+	flags  uint8
+	stream uint32
+}
+
+func parseFrameHeader(b []byte) frameHeader {
+	return frameHeader{
+		length: int(b[0])<<16 | int(b[1])<<8 | int(b[2]),
+		typ:    b[3],
+		flags:  b[4],
+		stream: binary.BigEndian.Uint32(b[5:]) & (1<<31 - 1),
+	}
+}
+
+func appendFrameHeader(dst []byte, length int, typ, flags uint8, stream uint32) []byte {
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), typ, flags)
+	return binary.BigEndian.AppendUint32(dst, stream)
+}
+
+// strip padding of DATA and HEADERS payload
+func unpad(h frameHeader, p []byte) ([]byte, bool) {
+	if h.flags&flagPadded == 0 {
+		return p, true
+	}
+	if len(p) == 0 {
+		return nil, false
+	}
+	pad := int(p[0])
+	p = p[1:]
+	if pad > len(p) {
+		return nil, false
+	}
+	return p[:len(p)-pad], true
+}
+
+// read-only view of string as bytes
+func s2b(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
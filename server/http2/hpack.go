@@ -0,0 +1,388 @@
+// HPACK (RFC 7541): decoder with dynamic table and huffman, encoder without indexing
+package http2
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errCompression = errors.New("hpack: bad header block")
+	errHuffman     = errors.New("hpack: bad huffman string")
+)
+
+type hfield struct {
+	name, value string
+}
+
+// 1-based, index 1 is staticTable[0]
+var staticTable = [...]hfield{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// static table indexes used by encoder
+const (
+	idxStatus        = 8
+	idxContentLength = 28
+	idxDate          = 33
+	idxServer        = 54
+)
+
+// default SETTINGS_HEADER_TABLE_SIZE
+const defaultTableSize = 4096
+
+// decoder state of one conn, dynamic table must live as long as conn
+type decoder struct {
+	dyn   []hfield // oldest first, newest entry is the last one
+	size  int      // sum of entry sizes (name + value + 32)
+	max   int      // current table size, changed by size update instruction
+	limit int      // max we advertised in SETTINGS
+
+	nbuf, vbuf []byte // huffman scratch
+}
+
+func newDecoder() decoder {
+	return decoder{max: defaultTableSize, limit: defaultTableSize}
+}
+
+// decode complete header block, name and value are valid only during emit
+func (d *decoder) decode(b []byte, emit func(name, value []byte)) error {
+	first := true
+	for len(b) > 0 {
+		c := b[0]
+		switch {
+		case c&0x80 != 0: // indexed field
+			idx, rest, err := readInt(b, 7)
+			if err != nil {
+				return err
+			}
+			f, ok := d.at(idx)
+			if !ok || idx == 0 {
+				return errCompression
+			}
+			emit(s2b(f.name), s2b(f.value))
+			b = rest
+
+		case c&0xe0 == 0x20: // table size update, allowed only at block start
+			if !first {
+				return errCompression
+			}
+			size, rest, err := readInt(b, 5)
+			if err != nil {
+				return err
+			}
+			if size > uint64(d.limit) {
+				return errCompression
+			}
+			d.max = int(size)
+			d.evict(0)
+			b = rest
+			continue
+
+		default: // literal, with incremental indexing (01) or without (0000, 0001)
+			prefix, index := uint8(4), false
+			if c&0xc0 == 0x40 {
+				prefix, index = 6, true
+			}
+			idx, rest, err := readInt(b, prefix)
+			if err != nil {
+				return err
+			}
+
+			var name []byte
+			if idx == 0 {
+				name, rest, err = d.readString(rest, &d.nbuf)
+				if err != nil {
+					return err
+				}
+			} else {
+				f, ok := d.at(idx)
+				if !ok {
+					return errCompression
+				}
+				name = s2b(f.name)
+			}
+			value, rest, err := d.readString(rest, &d.vbuf)
+			if err != nil {
+				return err
+			}
+
+			if index {
+				d.add(string(name), string(value))
+			}
+			emit(name, value)
+			b = rest
+		}
+		first = false
+	}
+	return nil
+}
+
+// field by hpack index: static first, then dynamic from newest
+func (d *decoder) at(idx uint64) (hfield, bool) {
+	if idx == 0 {
+		return hfield{}, false
+	}
+	if idx <= uint64(len(staticTable)) {
+		return staticTable[idx-1], true
+	}
+	di := idx - uint64(len(staticTable)) - 1
+	if di >= uint64(len(d.dyn)) {
+		return hfield{}, false
+	}
+	return d.dyn[len(d.dyn)-1-int(di)], true
+}
+
+func (d *decoder) add(name, value string) {
+	sz := len(name) + len(value) + 32
+	if sz > d.max {
+		// too big entry empties table and is not added
+		d.dyn = d.dyn[:0]
+		d.size = 0
+		return
+	}
+	d.evict(sz)
+	d.dyn = append(d.dyn, hfield{name, value})
+	d.size += sz
+}
+
+// drop oldest entries until there is room for n more bytes
+func (d *decoder) evict(n int) {
+	drop := 0
+	for d.size+n > d.max && drop < len(d.dyn) {
+		f := d.dyn[drop]
+		d.size -= len(f.name) + len(f.value) + 32
+		drop++
+	}
+	if drop > 0 {
+		d.dyn = append(d.dyn[:0], d.dyn[drop:]...)
+	}
+}
+
+// string literal, raw strings point into b, huffman ones are decoded into scratch
+func (d *decoder) readString(b []byte, scratch *[]byte) ([]byte, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errCompression
+	}
+	huff := b[0]&0x80 != 0
+	n, rest, err := readInt(b, 7)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > uint64(len(rest)) {
+		return nil, nil, errCompression
+	}
+	s, rest := rest[:n], rest[n:]
+	if !huff {
+		return s, rest, nil
+	}
+
+	out, err := huffDecode((*scratch)[:0], s)
+	*scratch = out
+	return out, rest, err
+}
+
+// hpack integer with n-bit prefix
+func readInt(b []byte, n uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errCompression
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(b[0]) & mask
+	b = b[1:]
+	if v < mask {
+		return v, b, nil
+	}
+
+	var m uint
+	for len(b) > 0 {
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << m
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+		m += 7
+		if m >= 63 {
+			break
+		}
+	}
+	return 0, nil, errCompression
+}
+
+func appendInt(dst []byte, n uint8, flags byte, v uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if v < mask {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(mask))
+	v -= mask
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// plain (not huffman) string literal
+func appendString(dst, s []byte) []byte {
+	dst = appendInt(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// literal without indexing with new name, name is lowercased as http2 requires
+func appendField(dst, name, value []byte) []byte {
+	dst = append(dst, 0)
+	dst = appendInt(dst, 7, 0, uint64(len(name)))
+	for _, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return appendString(dst, value)
+}
+
+// literal without indexing with static table name
+func appendIndexedName(dst []byte, idx uint64, value []byte) []byte {
+	dst = appendInt(dst, 4, 0, idx)
+	return appendString(dst, value)
+}
+
+// :status field, common codes are single byte static references
+func appendStatus(dst []byte, code int) []byte {
+	switch code {
+	case 200:
+		return append(dst, 0x80|8)
+	case 204:
+		return append(dst, 0x80|9)
+	case 206:
+		return append(dst, 0x80|10)
+	case 304:
+		return append(dst, 0x80|11)
+	case 400:
+		return append(dst, 0x80|12)
+	case 404:
+		return append(dst, 0x80|13)
+	case 500:
+		return append(dst, 0x80|14)
+	}
+	v := [3]byte{byte('0' + code/100), byte('0' + code/10%10), byte('0' + code%10)}
+	return appendIndexedName(dst, idxStatus, v[:])
+}
+
+// huffman decoding tree, built once from code table
+type huffNode struct {
+	next [2]int16 // child node index, 0 means no child (root is never a child)
+	sym  int16    // decoded byte for leaves, -1 for inner nodes
+}
+
+var (
+	huffTree []huffNode
+	huffOnce sync.Once
+)
+
+func buildHuffTree() {
+	huffTree = make([]huffNode, 1, 512)
+	huffTree[0].sym = -1
+	for sym, code := range huffCodes {
+		n := 0
+		for i := int(huffLens[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if huffTree[n].next[bit] == 0 {
+				huffTree = append(huffTree, huffNode{sym: -1})
+				huffTree[n].next[bit] = int16(len(huffTree) - 1)
+			}
+			n = int(huffTree[n].next[bit])
+		}
+		huffTree[n].sym = int16(sym)
+	}
+}
+
+// decode huffman string; padding must be shorter than 8 bits and all ones (EOS prefix)
+func huffDecode(dst, src []byte) ([]byte, error) {
+	huffOnce.Do(buildHuffTree)
+
+	n, depth, ones := 0, 0, true
+	for _, c := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (c >> uint(i)) & 1
+			next := huffTree[n].next[bit]
+			if next == 0 {
+				return dst, errHuffman
+			}
+			n = int(next)
+			depth++
+			ones = ones && bit == 1
+
+			if sym := huffTree[n].sym; sym >= 0 {
+				dst = append(dst, byte(sym))
+				n, depth, ones = 0, 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return dst, errHuffman
+	}
+	return dst, nil
+}
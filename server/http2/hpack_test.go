@@ -0,0 +1,183 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestHuffmanDecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"f1e3 c2e5 f23a 6ba0 ab90 f4ff", "www.example.com", true},
+		{"a8eb 1064 9cbf", "no-cache", true},
+		{"25a8 49e9 5ba9 7d7f", "custom-key", true},
+		{"ff", "", false},        // padding longer than 7 bits
+		{"fe", "", false},        // padding is not all ones
+		{"ffff ffff", "", false}, // EOS
+	}
+
+	for _, tt := range tests {
+		got, err := huffDecode(nil, unhex(tt.in))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.in, tt.ok, err)
+			continue
+		}
+		if tt.ok && string(got) != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+// RFC 7541 C.4: requests with huffman, dynamic table is shared between them
+func TestDecoder_RFCExamples(t *testing.T) {
+	blocks := []struct {
+		in   string
+		want string
+		size int
+	}{
+		{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			":method=GET :scheme=http :path=/ :authority=www.example.com", 57},
+		{"8286 84be 5886 a8eb 1064 9cbf",
+			":method=GET :scheme=http :path=/ :authority=www.example.com cache-control=no-cache", 110},
+		{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			":method=GET :scheme=https :path=/index.html :authority=www.example.com custom-key=custom-value", 164},
+	}
+
+	d := newDecoder()
+	for i, b := range blocks {
+		var got []string
+		err := d.decode(unhex(b.in), func(name, value []byte) {
+			got = append(got, fmt.Sprintf("%s=%s", name, value))
+		})
+		if err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if s := strings.Join(got, " "); s != b.want {
+			t.Errorf("block %d:\nexpected %s\ngot      %s", i, b.want, s)
+		}
+		if d.size != b.size {
+			t.Errorf("block %d: expected table size %d, got %d", i, b.size, d.size)
+		}
+	}
+
+	// bad blocks
+	for _, in := range []string{"80", "c0", "3fe21f", "8220"} {
+		if err := newDecoderErr(unhex(in)); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func newDecoderErr(b []byte) error {
+	d := newDecoder()
+	return d.decode(b, func(name, value []byte) {})
+}
+
+func TestEncoder_RoundTrip(t *testing.T) {
+	c := newConn(&engine.Session{}, nil)
+	c.out = c.out[:0]
+	headers := []engine.Header{
+		{Key: []byte("Content-Type"), Val: []byte("text/plain")},
+		{Key: []byte("Connection"), Val: []byte("close")}, // hop-by-hop, dropped
+		{Key: []byte("X-Long"), Val: bytes.Repeat([]byte("v"), 300)},
+	}
+	c.writeHeaders(1, 418, headers, 5, []byte("Mon, 19 Oct 2026 10:00:00 GMT"), false)
+
+	h := parseFrameHeader(c.out)
+	if h.typ != frameHeaders || h.flags != flagEndHeaders || h.stream != 1 {
+		t.Fatalf("bad frame header %+v", h)
+	}
+
+	var got []string
+	d := newDecoder()
+	if err := d.decode(c.out[frameHeaderLen:], func(name, value []byte) {
+		got = append(got, fmt.Sprintf("%s=%s", name, value))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := ":status=418 server=goserver date=Mon, 19 Oct 2026 10:00:00 GMT content-length=5 content-type=text/plain x-long=" + strings.Repeat("v", 300)
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("expected %s\ngot %s", want, s)
+	}
+}
+
+// h2c upgrade on session made by hand: 101, server SETTINGS, then response to request on stream 1
+func TestUpgrade(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	f := os.NewFile(uintptr(fds[1]), "client")
+	client, _ := net.FileConn(f)
+	f.Close()
+	defer client.Close()
+
+	s := &engine.Session{Fd: uint32(fds[0]), Buf: make([]byte, 1<<16-1)}
+	req := "GET /up HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n"
+	s.Offset = uint32(copy(s.Buf, req))
+
+	var path string
+	p := &protocol.HTTPParser{}
+	p.Parse(s, func(s *engine.Session, buf []byte) {
+		ok := Upgrade(s, func(sub *engine.Session) {
+			path = string(sub.Req.Path.AsBuf(sub))
+			sub.Responder.Respond(sub, 200, nil, []byte("upgraded"))
+		})
+		if !ok {
+			t.Fatal("upgrade refused")
+		}
+	})
+	if !s.Hijacked() || path != "/up" {
+		t.Fatalf("stream 1 is not served (path %q)", path)
+	}
+
+	res101 := make([]byte, len(res101H2C))
+	io.ReadFull(client, res101)
+	if !bytes.Equal(res101, res101H2C) {
+		t.Fatalf("bad 101: %q", res101)
+	}
+
+	var typs []uint8
+	var body []byte
+	for len(typs) < 3 {
+		var hb [frameHeaderLen]byte
+		if _, err := io.ReadFull(client, hb[:]); err != nil {
+			t.Fatal(err)
+		}
+		h := parseFrameHeader(hb[:])
+		payload := make([]byte, h.length)
+		io.ReadFull(client, payload)
+		typs = append(typs, h.typ)
+		if h.typ == frameData {
+			body = payload
+			if h.flags&flagEndStream == 0 || h.stream != 1 {
+				t.Errorf("bad DATA frame %+v", h)
+			}
+		}
+	}
+	if typs[0] != frameSettings || typs[1] != frameHeaders || typs[2] != frameData || string(body) != "upgraded" {
+		t.Errorf("unexpected frames %v, body %q", typs, body)
+	}
+}
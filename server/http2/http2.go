@@ -0,0 +1,122 @@
+// HTTP/2 over cleartext (h2c, RFC 9113) on top of epoll engine:
+// conn is hijacked, every stream gets its own request session that goes through router like http/1 request
+package http2
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/s00inx/goserver/server/engine"
+)
+
+// serves one request session, response goes back through session Responder
+type Handler func(s *engine.Session)
+
+var (
+	hUpgrade  = []byte("Upgrade")
+	hSettings = []byte("Http2-Settings")
+	vH2C      = []byte("h2c")
+	vUpgrade  = []byte("upgrade")
+	res101H2C = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+)
+
+// buffer starts with client connection preface (prior knowledge h2c),
+// more means buffer is too short to tell yet
+func IsPreface(b []byte) (ok, more bool) {
+	if len(b) < len(clientPreface) {
+		return false, len(b) > 0 && bytes.HasPrefix(clientPreface, b)
+	}
+	return bytes.HasPrefix(b, clientPreface), false
+}
+
+// switch conn that starts with client preface to http2 and handle what is already read
+func Serve(s *engine.Session, h Handler) (bool, error) {
+	c := newConn(s, h)
+	c.attach()
+	return c.serve(s)
+}
+
+// answer "Upgrade: h2c" request with 101 and continue conn as http2,
+// request itself becomes stream 1; returns false if request doesn't ask for it
+// (or has a body, such requests are served as http/1)
+func Upgrade(s *engine.Session, h Handler) bool {
+	if !hasToken(header(s, hUpgrade), vH2C) || !hasToken(s.KnownHeader(engine.HConnection), vUpgrade) {
+		return false
+	}
+	if s.Req.Body.End > s.Req.Body.St {
+		return false
+	}
+	enc, ok := headerOnce(s, hSettings)
+	if !ok {
+		return false
+	}
+
+	// base64url without padding, but padded one is tolerated
+	var raw [256]byte
+	enc = bytes.TrimRight(enc, "=")
+	if base64.RawURLEncoding.DecodedLen(len(enc)) > len(raw) {
+		return false
+	}
+	n, err := base64.RawURLEncoding.Decode(raw[:], enc)
+	if err != nil || n%6 != 0 {
+		return false
+	}
+
+	c := newConn(s, h)
+	if c.applySettings(raw[:n]) != nil {
+		return false
+	}
+
+	// pipelined http/1 responses must go before 101
+	if s.Flush() != nil {
+		return false
+	}
+	if _, err := s.WriteDirect(res101H2C, nil); err != nil {
+		return false
+	}
+	c.attach()
+
+	// stream 1 is half-closed by client already, copy request as is (views stay valid)
+	c.lastID = 1
+	st := c.newStream(1)
+	sub := st.sub
+	copy(sub.Buf, s.Buf[:s.Offset])
+	sub.Req = s.Req
+	sub.Req.Body = engine.View{}
+	sub.Hbuf = s.Hbuf
+	st.recvEnd = true
+
+	c.dispatch(st)
+	c.flush()
+	return true
+}
+
+// request header of http/1 session, names are compared case-insensitively
+func header(s *engine.Session, key []byte) []byte {
+	v, _ := headerOnce(s, key)
+	return v
+}
+
+// header value and whether it is there exactly once
+func headerOnce(s *engine.Session, key []byte) ([]byte, bool) {
+	var v []byte
+	cnt := 0
+	for i := range int(s.Req.Hcount) {
+		h := &s.Hbuf[i]
+		if bytes.EqualFold(h.Key.AsBuf(s), key) {
+			v = h.Val.AsBuf(s)
+			cnt++
+		}
+	}
+	return v, cnt == 1
+}
+
+// comma separated header value contains token (case-insensitive)
+func hasToken(v, tok []byte) bool {
+	for part := range bytes.SplitSeq(v, []byte{','}) {
+		if bytes.EqualFold(bytes.TrimSpace(part), tok) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package http2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	srv "github.com/s00inx/goserver/server"
+	"github.com/s00inx/goserver/server/goservertest"
+)
+
+func newH2Server(t *testing.T) (*goservertest.Server, *http.Client) {
+	s := srv.NewWithConfig(srv.Config{H2C: true})
+	s.Get("/hello", func(c *srv.Context) {
+		c.SetHeader([]byte("X-Proto"), c.Protocol())
+		c.SendDirect(200, []byte("hello "+string(c.Header([]byte("User-Agent")))))
+	})
+	s.Post("/echo", func(c *srv.Context) {
+		c.SendDirect(201, c.Body())
+	})
+	s.Get("/big", func(c *srv.Context) {
+		c.SendDirect(200, bytes.Repeat([]byte("x"), 300<<10)) // bigger than default windows
+	})
+	s.Get("/user/:id", func(c *srv.Context) {
+		c.SendDirect(200, c.Param([]byte("id")))
+	})
+	ts := goservertest.Start(t, s)
+
+	var protos http.Protocols
+	protos.SetUnencryptedHTTP2(true)
+	tr := &http.Transport{Protocols: &protos}
+	t.Cleanup(tr.CloseIdleConnections)
+	return ts, &http.Client{Transport: tr}
+}
+
+func get(t *testing.T, cl *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+	res, err := cl.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(b)
+}
+
+func TestH2C_PriorKnowledge(t *testing.T) {
+	ts, cl := newH2Server(t)
+	base := "http://" + ts.Addr
+
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	req.Header.Set("User-Agent", "h2-test")
+	res, err := cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.ProtoMajor != 2 || res.StatusCode != 200 || string(body) != "hello h2-test" {
+		t.Fatalf("got %s %d %q", res.Proto, res.StatusCode, body)
+	}
+	if res.Header.Get("X-Proto") != "HTTP/2.0" || res.Header.Get("Server") != "goserver" {
+		t.Errorf("bad headers %v", res.Header)
+	}
+
+	res, err = cl.Post(base+"/echo", "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 201 || string(body) != "ping" {
+		t.Errorf("echo: got %d %q", res.StatusCode, body)
+	}
+
+	if res, body := get(t, cl, base+"/missing"); res.StatusCode != 404 || body != "Not Found" {
+		t.Errorf("404: got %d %q", res.StatusCode, body)
+	}
+
+	// flow control: body is sent as peer opens window
+	if res, body := get(t, cl, base+"/big"); res.StatusCode != 200 || len(body) != 300<<10 {
+		t.Errorf("big: got %d, %d bytes", res.StatusCode, len(body))
+	}
+
+	// request body: window is credited only while it fits stream buffer, bigger body gets 413, not a stall
+	for size, code := range map[int]int{40 << 10: 201, 64000: 201, 65535: 413, 200 << 10: 413} {
+		res, err := cl.Post(base+"/echo", "text/plain", bytes.NewReader(bytes.Repeat([]byte("y"), size)))
+		if err != nil {
+			t.Fatalf("%d byte body: %v", size, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != code || (code == 201 && len(body) != size) {
+			t.Errorf("%d byte body: got %d, %d bytes", size, res.StatusCode, len(body))
+		}
+	}
+}
+
+// raw frame, header block is literal fields without indexing so no hpack encoder is needed
+func frame(typ, flags byte, id uint32, p []byte) []byte {
+	b := []byte{byte(len(p) >> 16), byte(len(p) >> 8), byte(len(p)), typ, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[5:], id)
+	return append(b, p...)
+}
+
+func literals(kv ...string) []byte {
+	var b []byte
+	for i := 0; i < len(kv); i += 2 {
+		b = append(b, 0, byte(len(kv[i])))
+		b = append(b, kv[i]...)
+		b = append(b, byte(len(kv[i+1])))
+		b = append(b, kv[i+1]...)
+	}
+	return b
+}
+
+// content-length that doesn't match DATA is malformed request, stream gets RST_STREAM(PROTOCOL_ERROR)
+func TestH2C_ContentLengthMismatch(t *testing.T) {
+	ts, _ := newH2Server(t)
+	nc, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	post := func(id uint32, clen string) []byte {
+		b := frame(1, 4, id, literals(":method", "POST", ":scheme", "http", ":path", "/echo", ":authority", "x", "content-length", clen))
+		return append(b, frame(0, 1, id, []byte("ping"))...)
+	}
+	var out []byte
+	out = append(out, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"...)
+	out = append(out, frame(4, 0, 0, nil)...)
+	out = append(out, post(1, "10")...) // less than declared
+	out = append(out, post(3, "2")...)  // more than declared
+	out = append(out, post(5, "4")...)
+	if _, err := nc.Write(out); err != nil {
+		t.Fatal(err)
+	}
+
+	nc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	rst := map[uint32]uint32{}
+	for {
+		var h [9]byte
+		if _, err := io.ReadFull(nc, h[:]); err != nil {
+			t.Fatalf("no response for valid stream: %v, resets %v", err, rst)
+		}
+		p := make([]byte, int(h[0])<<16|int(h[1])<<8|int(h[2]))
+		if _, err := io.ReadFull(nc, p); err != nil {
+			t.Fatal(err)
+		}
+		id := binary.BigEndian.Uint32(h[5:]) & 0x7fffffff
+		switch {
+		case h[3] == 3:
+			rst[id] = binary.BigEndian.Uint32(p)
+		case h[3] == 1 && id == 5:
+			if rst[1] != 1 || rst[3] != 1 {
+				t.Errorf("resets: got %v, want PROTOCOL_ERROR on 1 and 3", rst)
+			}
+			return
+		case h[3] == 1:
+			t.Fatalf("stream %d with bad content-length was answered", id)
+		}
+	}
+}
+
+func TestH2C_Multiplexing(t *testing.T) {
+	ts, cl := newH2Server(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := range 50 {
+		wg.Go(func() {
+			url := fmt.Sprintf("http://%s/user/%d", ts.Addr, i)
+			if i%5 == 0 {
+				url = "http://" + ts.Addr + "/big"
+			}
+			res, err := cl.Get(url)
+			if err != nil {
+				errs <- err
+				return
+			}
+			b, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			switch {
+			case err != nil:
+				errs <- err
+			case res.ProtoMajor != 2:
+				errs <- fmt.Errorf("%d: proto %s", i, res.Proto)
+			case i%5 != 0 && string(b) != fmt.Sprint(i):
+				errs <- fmt.Errorf("%d: got %q", i, b)
+			case i%5 == 0 && len(b) != 300<<10:
+				errs <- fmt.Errorf("%d: big body is %d bytes", i, len(b))
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestH2C_PlainHTTP1Still(t *testing.T) {
+	ts, _ := newH2Server(t)
+	c := ts.Client(t)
+
+	res, err := c.Do(goservertest.Request{Path: "/user/7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Proto != "HTTP/1.1" || string(res.Body) != "7" {
+		t.Errorf("got %s %q", res.Proto, res.Body)
+	}
+}
@@ -30,6 +30,11 @@ var statusTable = [505][]byte{
 	405: []byte("405 Method Not Allowed"),
 	408: []byte("408 Request Timeout"),
 	413: []byte("413 Payload Too Large"),
+	414: []byte("414 URI Too Long"),
+	417: []byte("417 Expectation Failed"),
+	422: []byte("422 Unprocessable Entity"),
+	426: []byte("426 Upgrade Required"),
+	431: []byte("431 Request Header Fields Too Large"),
 
 	// 5xx
 	500: []byte("500 Internal Server Error"),
@@ -49,6 +54,14 @@ var (
 	hdate   = []byte("Date: ")
 )
 
+// status line text for code ("404 Not Found"), nil for unknown codes
+func StatusText(code int) []byte {
+	if code < 100 || code > 504 {
+		return nil
+	}
+	return statusTable[code]
+}
+
 // helper func to copy int to pre-allocated buf with zero-alloc, buf is dst[n:]
 // n should be uint bc / 10 (and % 10) for uints is faster (compiler use division by invariant integers), and our len or code > 0
 func IntToBuf(buf []byte, n uint) int {
@@ -67,8 +80,20 @@ func IntToBuf(buf []byte, n uint) int {
 	return copy(buf, tmp[i:])
 }
 
-// build response w zero alloc
-func BuildResp(code int, headers []engine.Header, body, dst []byte) int {
+// build response w zero alloc,
+// date is Date header value from engine cache (Session.Date), no Date header if it is nil
+func BuildResp(code int, headers []engine.Header, body, dst, date []byte) int {
+	n := BuildHeader(code, headers, len(body), dst, date)
+	if len(body) > 0 {
+		n += copy(dst[n:], body)
+	}
+
+	return n
+}
+
+// build only status line and header block (with Content-Length of bodylen, none if bodylen < 0),
+// body is sent separately by engine.WriteV so it is never copied
+func BuildHeader(code int, headers []engine.Header, bodylen int, dst, date []byte) int {
 	if code < 100 || code > 504 {
 		code = 500
 	}
@@ -83,20 +108,19 @@ func BuildResp(code int, headers []engine.Header, body, dst []byte) int {
 	n += copy(dst[n:], crlf)
 
 	// i calculate content len here bc i am forced to convert it to []byte anyway
-	n += copy(dst[n:], clhdr)
-	var tmp [64]byte
-	nn := IntToBuf(tmp[:], uint(len(body)))
-	n += copy(dst[n:], tmp[:nn])
-	n += copy(dst[n:], crlf)
-	//
+	// 1xx, 204 and 304 must not have it (101 for upgrades especially),
+	// negative bodylen is for streamed bodies that are framed other way
+	if code >= 200 && code != 204 && code != 304 && bodylen >= 0 {
+		n += copy(dst[n:], clhdr)
+		n += IntToBuf(dst[n:], uint(bodylen))
+		n += copy(dst[n:], crlf)
+	}
 
 	n += copy(dst[n:], hserver)
 
-	// load atomic.Pointer
-	cdate := engine.CurrentDate.Load()
-	if cdate != nil {
+	if date != nil {
 		n += copy(dst[n:], hdate)
-		n += copy(dst[n:], *cdate)
+		n += copy(dst[n:], date)
 		n += copy(dst[n:], crlf)
 	}
 
@@ -110,9 +134,5 @@ func BuildResp(code int, headers []engine.Header, body, dst []byte) int {
 	}
 
 	n += copy(dst[n:], crlf)
-	if len(body) > 0 {
-		n += copy(dst[n:], body)
-	}
-
 	return n
 }
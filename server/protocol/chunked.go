@@ -0,0 +1,146 @@
+package protocol
+
+import "github.com/s00inx/goserver/server/engine"
+
+// chunked transfer coding (RFC 9112 7.1)
+
+// decoder states
+const (
+	chSize        uint8 = iota // chunk size hex digits
+	chExt                      // chunk extensions up to CR, skipped
+	chSizeLF                   // LF after chunk size line
+	chData                     // chunk data
+	chDataCR                   // CRLF after chunk data
+	chDataLF                   // (chunk data CRLF)
+	chTrailer                  // start of trailer line or final CRLF
+	chTrailerLine              // trailer field line up to CR
+	chTrailerLF                // LF after trailer line
+	chEndLF                    // LF of final CRLF
+	chDone
+)
+
+const maxChunkSizeLen = 15 // hex digits, so size never overflows int64
+
+// incremental decoder of chunked body: feed it with raw bytes as they come,
+// decoded data is written to dst which can be the same buffer as src (output never outruns input).
+// zero value is ready to use; state lives in engine.Session between reads, so request body
+// is decoded once as it comes in
+type ChunkedDecoder engine.ChunkState
+
+// decode as much of src as possible, nil dst only checks framing and counts body length;
+// returns decoded and consumed byte counts, after last chunk and trailers decoder is Done
+// and rest of src is not touched (it is the next request)
+func (d *ChunkedDecoder) Decode(dst, src []byte) (nw, nr int, err error) {
+	for nr < len(src) && d.State != chDone {
+		c := src[nr]
+		switch d.State {
+		case chSize:
+			switch {
+			case IsHex(c):
+				if d.Digits == maxChunkSizeLen {
+					return nw, nr, errInvalid
+				}
+				d.Size = d.Size<<4 | int64(Unhex(c))
+				d.Digits++
+			case d.Digits == 0:
+				return nw, nr, errInvalid
+			case c == ';' || c == ' ' || c == '\t':
+				d.State = chExt
+			case c == '\r':
+				d.State = chSizeLF
+			default:
+				return nw, nr, errInvalid
+			}
+		case chExt:
+			// chunk-ext = *( BWS ";" BWS token [ "=" value ] ), nobody uses them so just skip
+			if c == '\r' {
+				d.State = chSizeLF
+			} else if c < ' ' && c != '\t' || c == 0x7f {
+				return nw, nr, errInvalid
+			}
+		case chSizeLF:
+			if c != '\n' {
+				return nw, nr, errInvalid
+			}
+			d.Digits = 0
+			if d.Size == 0 {
+				d.State = chTrailer
+				d.Trailer = d.Read + nr + 1
+			} else {
+				d.State = chData
+			}
+		case chData:
+			n := min(int64(len(src)-nr), d.Size)
+			if dst != nil {
+				copy(dst[nw:], src[nr:nr+int(n)])
+			}
+			nw += int(n)
+			nr += int(n)
+			d.Size -= n
+			if d.Size == 0 {
+				d.State = chDataCR
+			}
+			continue
+		case chDataCR:
+			if c != '\r' {
+				return nw, nr, errInvalid
+			}
+			d.State = chDataLF
+		case chDataLF:
+			if c != '\n' {
+				return nw, nr, errInvalid
+			}
+			d.State = chSize
+		case chTrailer:
+			if c == '\r' {
+				d.State = chEndLF
+			} else {
+				d.State = chTrailerLine
+			}
+		case chTrailerLine:
+			if c == '\r' {
+				d.State = chTrailerLF
+			} else if c == '\n' {
+				return nw, nr, errInvalid
+			}
+		case chTrailerLF:
+			if c != '\n' {
+				return nw, nr, errInvalid
+			}
+			d.State = chTrailer
+		case chEndLF:
+			if c != '\n' {
+				return nw, nr, errInvalid
+			}
+			d.State = chDone
+		}
+		nr++
+	}
+	d.Read += nr
+	d.Written += nw
+	return nw, nr, nil
+}
+
+// last chunk and trailer section are read
+func (d *ChunkedDecoder) Done() bool { return d.State == chDone }
+
+// decoded body length so far
+func (d *ChunkedDecoder) Len() int { return d.Written }
+
+// raw offset of trailer section (field lines with CRLF each, then final CRLF), valid when Done
+func (d *ChunkedDecoder) TrailerAt() int { return d.Trailer }
+
+// hex digit helpers, shared with router for percent-decoding
+func IsHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func Unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
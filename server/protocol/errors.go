@@ -2,8 +2,36 @@ package protocol
 
 import "errors"
 
+// parse error that should be answered with HTTP status before closing conn
+type StatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e *StatusError) Error() string { return e.Msg }
+
 // errors for parsing
 var (
-	errInvalid    = errors.New("invalid request")
+	errInvalid    = &StatusError{Code: 400, Msg: "invalid request"}
 	errIncomplete = errors.New("incomplete request")
+	errExpect     = errors.New("request waits for 100 continue")
+
+	ErrURITooLong      = &StatusError{Code: 414, Msg: "request target too long"}
+	ErrHeaderTooLarge  = &StatusError{Code: 431, Msg: "request header fields too large"}
+	ErrPayloadTooLarge = &StatusError{Code: 413, Msg: "payload too large"}
+	ErrExpectation     = &StatusError{Code: 417, Msg: "expectation failed"}
+
+	errProxyInvalid = errors.New("invalid proxy protocol header")
 )
+
+// exported alias for malformed request error (400)
+var ErrBadRequest = errInvalid
+
+// HTTP status for parse error, 0 if error should just close the conn (proxy header for example)
+func StatusCode(err error) int {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return 0
+}
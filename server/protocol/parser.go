@@ -11,7 +11,65 @@ import (
 
 // stateless HTTPParser struct
 // should be init in server.go
-type HTTPParser struct{}
+type HTTPParser struct {
+	Limits
+
+	// accept some ambiguous framing of legacy clients (RFC 9112 strict parsing is default):
+	// repeated Content-Length with same value, Content-Length with Transfer-Encoding
+	// (chunked wins, conn is closed after response), non-token bytes in method and header names
+	Lenient bool
+
+	// called once headers of request with Expect: 100-continue are read and body is not here yet,
+	// returns 100 to let client send body or final status to reject request (conn is closed then);
+	// nil accepts every body
+	Expect func(s *engine.Session) int
+}
+
+// request size limits, zero field means default
+type Limits struct {
+	MaxURI         int // request target length, 414 (default 8KB)
+	MaxHeaders     int // header count, 431 (default and max is len(Session.Hbuf))
+	MaxHeaderBytes int // request line + headers size, 431 (default 16KB)
+	MaxBody        int // Content-Length, 413 (default and max is what fits in session buffer)
+}
+
+const (
+	defMaxURI         = 8 << 10
+	defMaxHeaderBytes = 16 << 10
+
+	maxMethod = 32 // longer method without space is garbage, not incomplete request
+	maxProto  = 16 // len("HTTP/1.1\r") with some room
+
+	maxContentLen = 1 << 40 // way over any buffer, only guards int overflow
+)
+
+// limits with defaults applied, hcap is header buffer size
+func (l *Limits) resolve(hcap int) (maxuri, maxh, maxhb, maxbody int) {
+	maxuri, maxh, maxhb, maxbody = l.MaxURI, l.MaxHeaders, l.MaxHeaderBytes, l.MaxBody
+	if maxuri <= 0 {
+		maxuri = defMaxURI
+	}
+	if maxh <= 0 || maxh > hcap {
+		maxh = hcap
+	}
+	if maxhb <= 0 {
+		maxhb = defMaxHeaderBytes
+	}
+	if maxbody <= 0 {
+		maxbody = int(^uint(0) >> 1)
+	}
+	return
+}
+
+var (
+	http10     = []byte("HTTP/1.0")
+	tClose     = []byte("close")
+	tKeepAlive = []byte("keep-alive")
+	tChunked   = []byte("chunked")
+	hExpect    = []byte("Expect")
+	t100       = []byte("100-continue")
+	res100     = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+)
 
 // callback func for handling parsed data,
 // so it is called when parser did full request
@@ -21,9 +79,10 @@ type HandleParsedFunc func(s *engine.Session, buf []byte)
 func (p *HTTPParser) Parse(s *engine.Session, onreq HandleParsedFunc) (bool, error) {
 	var err error
 	for {
-		cons, parserr := p.parseRaw(s.Buf[:s.Offset], s.Hbuf[:], &s.Req)
+		cons, parserr := p.parseRaw(s.Buf[:s.Offset], s.Hbuf[:], &s.Req, (*ChunkedDecoder)(&s.Chunk))
 		if parserr == nil {
 			onreq(s, s.Buf[:cons])
+			conn := s.Req.Conn
 
 			rem := int(s.Offset) - cons
 			if rem > 0 {
@@ -31,13 +90,28 @@ func (p *HTTPParser) Parse(s *engine.Session, onreq HandleParsedFunc) (bool, err
 			}
 			s.Offset = uint32(rem)
 			s.Req = engine.RawRequest{}
+			s.Chunk = engine.ChunkState{}
+			s.Flags &^= engine.FlagContinued
 
+			// handler switched protocols, rest of buffer is not http anymore
+			if s.Hijacked() {
+				return s.Offset == 0, nil
+			}
+			// response closes conn, pipelined requests after it are not served
+			if conn == engine.ConnClose {
+				s.Offset = 0
+				s.CloseAfterWrite()
+				return true, nil
+			}
 			if s.Offset == 0 {
 				return true, nil
 			}
 			continue
 		} else if errors.Is(parserr, errIncomplete) {
 			break
+		} else if parserr == errExpect {
+			err = p.expect(s)
+			break
 		} else {
 			err = parserr
 			break
@@ -50,9 +124,70 @@ func (p *HTTPParser) Parse(s *engine.Session, onreq HandleParsedFunc) (bool, err
 	return false, nil
 }
 
-// input raw data bytes, buffer for headers, RawRequest ptr from session struct
-func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.RawRequest) (int, error) {
+// Content-Length value: digits with optional whitespace around,
+// lenient also takes list of same values ("5, 5") that some proxies make
+func (p *HTTPParser) contentLength(v []byte) (int, error) {
+	v = bytes.Trim(v, " \t")
+	if p.Lenient {
+		if i := bytes.IndexByte(v, ','); i != -1 {
+			first := bytes.TrimRight(v[:i], " \t")
+			for part := range bytes.SplitSeq(v[i+1:], []byte{','}) {
+				if !bytes.Equal(bytes.Trim(part, " \t"), first) {
+					return 0, errInvalid
+				}
+			}
+			v = first
+		}
+	}
+	if len(v) == 0 {
+		return 0, errInvalid
+	}
+	n := 0
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return 0, errInvalid
+		}
+		n = n*10 + int(c-'0')
+		if n > maxContentLen {
+			return 0, ErrPayloadTooLarge
+		}
+	}
+	return n, nil
+}
+
+// client waits before sending body: ask hook once per request and send interim 100
+func (p *HTTPParser) expect(s *engine.Session) error {
+	if s.Flags&engine.FlagContinued != 0 {
+		return nil
+	}
+	code := 100
+	if p.Expect != nil {
+		code = p.Expect(s)
+	}
+	if code != 100 {
+		// only error statuses we have text for make a valid final response
+		if code < 400 || StatusText(code) == nil {
+			code = 417
+		}
+		return &StatusError{Code: code, Msg: "request rejected before body"}
+	}
+	s.Flags |= engine.FlagContinued
+
+	// responses to pipelined requests go first
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	_, err := s.WriteDirect(res100, nil)
+	return err
+}
+
+// input raw data bytes, buffer for headers, RawRequest ptr and chunked decoder state from session struct
+// (nil decoder means request is complete in raw, tests and one-shot parsing)
+func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.RawRequest, d *ChunkedDecoder) (int, error) {
 	crs := 0
+	// request is parsed from scratch on every call, so drop views of previous incomplete try
+	*req = engine.RawRequest{}
+	maxuri, maxh, maxhb, maxbody := p.resolve(len(hbuf))
 
 	// find a separator
 	findsep := func(start int, sep byte) int {
@@ -66,8 +201,17 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 	// find RawRequest method
 	sep := findsep(crs, ' ')
 	if sep == -1 {
+		if len(raw) > maxMethod {
+			return 0, errInvalid
+		}
 		return 0, errIncomplete
 	}
+	if sep == 0 || sep > maxMethod {
+		return 0, errInvalid
+	}
+	if !p.Lenient && !isToken(raw[:sep]) {
+		return 0, errInvalid
+	}
 	req.Method = engine.View{
 		St:  uint16(crs),
 		End: uint16(sep),
@@ -77,8 +221,14 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 	// find RawRequest path
 	sep = findsep(crs, ' ')
 	if sep == -1 {
+		if len(raw)-crs > maxuri {
+			return 0, ErrURITooLong
+		}
 		return 0, errIncomplete
 	}
+	if sep-crs > maxuri {
+		return 0, ErrURITooLong
+	}
 	req.Path = engine.View{
 		St:  uint16(crs),
 		End: uint16(sep),
@@ -88,6 +238,9 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 	// find RawRequest protocol (basically HTTP\1.1)
 	sep = findsep(crs, '\n')
 	if sep == -1 {
+		if len(raw)-crs > maxProto {
+			return 0, errInvalid
+		}
 		return 0, errIncomplete
 	}
 	if sep > crs && raw[sep-1] == '\r' {
@@ -100,12 +253,24 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 		return 0, errInvalid
 	}
 
+	// HTTP/1.0 closes conn by default, 1.1 keeps it
+	is10 := bytes.Equal(raw[req.Protocol.St:req.Protocol.End], http10)
+	keepalive, closeconn := false, false
+
 	// find RawRequest headers
 	var contentlen int
+	haslen, chunked, expect := false, false, false
 	clh := []byte("Content-Length")
 	for {
+		if crs > maxhb {
+			return 0, ErrHeaderTooLarge
+		}
 		// check if we are out of bounds
 		if crs+1 >= len(raw) {
+			// whole buffer is taken by headers and they are still not over
+			if len(raw) >= cap(raw) {
+				return 0, ErrHeaderTooLarge
+			}
 			return 0, errIncomplete
 		}
 
@@ -118,6 +283,9 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 		// header parsing process
 		lf := findsep(crs, '\n')
 		if lf == -1 {
+			if len(raw) > maxhb || len(raw) >= cap(raw) {
+				return 0, ErrHeaderTooLarge
+			}
 			return 0, errIncomplete
 		}
 		if raw[lf-1] != '\r' {
@@ -144,21 +312,64 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 			End: uint16(le),
 		}
 
-		// max header count is .. so we need to check overflow
+		// max header count is limited by session Hbuf, more headers is 431 (not silent drop)
 		hi := int(req.Hcount)
-		if hi < cap(hbuf) {
-			hbuf[hi] = engine.HeaderView{Key: key, Val: val}
-			req.Hcount++
+		if hi >= maxh {
+			return 0, ErrHeaderTooLarge
+		}
+		hbuf[hi] = engine.HeaderView{Key: key, Val: val}
+		req.Hcount++
+		known := req.Index(raw[crs:coloni], hi)
+
+		// header name is token, so no whitespace before colon and no obs-fold
+		if !p.Lenient && !isToken(raw[crs:coloni]) {
+			return 0, errInvalid
 		}
 
 		// find content-length header for body
 		// note: no Content-Lentgth means req has NO body
 		if coloni-crs == 14 && (raw[crs] == 'C' || raw[crs] == 'c') {
 			if bytes.EqualFold(clh, raw[crs:coloni]) {
-				for _, c := range raw[vals:le] {
-					if c >= '0' && c <= '9' {
-						contentlen = contentlen*10 + int(c-'0')
-					}
+				n, err := p.contentLength(raw[vals:le])
+				if err != nil {
+					return 0, err
+				}
+				// second Content-Length is smuggling attempt unless lenient and the same
+				if haslen && (!p.Lenient || n != contentlen) {
+					return 0, errInvalid
+				}
+				contentlen, haslen = n, true
+			}
+		}
+
+		// Transfer-Encoding: chunked must be the last coding, otherwise body length is unknown
+		if known == engine.HTransferEncoding {
+			v := raw[vals:le]
+			if i := bytes.LastIndexByte(v, ','); i != -1 {
+				v = v[i+1:]
+			}
+			if !bytes.EqualFold(bytes.TrimSpace(v), tChunked) {
+				return 0, errInvalid
+			}
+			chunked = true
+		}
+
+		// only 100-continue expectation is known (ignored for http/1.0 as RFC says)
+		if coloni-crs == 6 && bytes.EqualFold(hExpect, raw[crs:coloni]) && !is10 {
+			if !bytes.EqualFold(bytes.TrimSpace(raw[vals:le]), t100) {
+				return 0, ErrExpectation
+			}
+			expect = true
+		}
+
+		// Connection: close / keep-alive (comma separated tokens)
+		if known == engine.HConnection {
+			for tok := range bytes.SplitSeq(raw[vals:le], []byte{','}) {
+				tok = bytes.TrimSpace(tok)
+				if bytes.EqualFold(tok, tClose) {
+					closeconn = true
+				} else if bytes.EqualFold(tok, tKeepAlive) {
+					keepalive = true
 				}
 			}
 		}
@@ -166,9 +377,46 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 		crs = lf + 1
 	}
 
-	// parsing body
+	// both lengths: strict rejects, lenient trusts chunked and drops conn after response
+	if chunked && haslen {
+		if !p.Lenient {
+			return 0, errInvalid
+		}
+		contentlen = 0
+		closeconn = true
+	}
+
+	switch {
+	case closeconn, is10 && !keepalive:
+		req.Conn = engine.ConnClose
+	case is10:
+		req.Conn = engine.ConnKeepAlive10
+	}
+
+	// chunked body wins over Content-Length
+	if chunked {
+		if d == nil {
+			d = &ChunkedDecoder{}
+		}
+		n, err := p.parseChunked(raw, crs, hbuf, req, d, maxh, maxbody)
+		if expect && err == errIncomplete {
+			return 0, errExpect
+		}
+		if err != nil {
+			return 0, err
+		}
+		return crs + n, nil
+	}
+
+	// parsing body, it must fit in session buffer after headers
+	if contentlen > maxbody || contentlen > cap(raw)-crs {
+		return 0, ErrPayloadTooLarge
+	}
 	if contentlen > 0 {
 		if crs+contentlen > len(raw) {
+			if expect {
+				return 0, errExpect
+			}
 			return 0, errIncomplete
 		}
 		req.Body = engine.View{
@@ -180,3 +428,59 @@ func (p *HTTPParser) parseRaw(raw []byte, hbuf []engine.HeaderView, req *engine.
 
 	return crs, nil
 }
+
+// chunked body starting at crs: only bytes that came since last call are fed to decoder,
+// data is decoded in place right after previous data (so body view is contiguous),
+// trailer fields go to hbuf after headers; returns raw body length
+func (p *HTTPParser) parseChunked(raw []byte, crs int, hbuf []engine.HeaderView, req *engine.RawRequest, d *ChunkedDecoder, maxh, maxbody int) (int, error) {
+	if !d.Done() {
+		body := raw[crs:]
+		if _, _, err := d.Decode(body[d.Written:], body[d.Read:]); err != nil {
+			return 0, err
+		}
+	}
+	if d.Len() > maxbody {
+		return 0, ErrPayloadTooLarge
+	}
+	if !d.Done() {
+		if len(raw) >= cap(raw) {
+			return 0, ErrPayloadTooLarge
+		}
+		return 0, errIncomplete
+	}
+
+	n, tr := d.Read, d.TrailerAt()
+	req.Body = engine.View{
+		St:  uint16(crs),
+		End: uint16(crs + d.Len()),
+	}
+
+	// trailer lines are after last chunk, decoded data never reaches them
+	for st := crs + tr; st < crs+n-2; {
+		lf := st + bytes.IndexByte(raw[st:], '\n') // framing is checked by decoder, line ends with CRLF
+		end := lf - 1
+		coloni := bytes.IndexByte(raw[st:end], ':')
+		if coloni <= 0 {
+			return 0, errInvalid
+		}
+		// same rule as for headers: name is token, no whitespace before colon, no obs-fold
+		if !p.Lenient && !isToken(raw[st:st+coloni]) {
+			return 0, errInvalid
+		}
+		hi := int(req.Hcount + req.Tcount)
+		if hi >= maxh {
+			return 0, ErrHeaderTooLarge
+		}
+		vals := st + coloni + 1
+		for vals < end && (raw[vals] == ' ' || raw[vals] == '\t') {
+			vals++
+		}
+		hbuf[hi] = engine.HeaderView{
+			Key: engine.View{St: uint16(st), End: uint16(st + coloni)},
+			Val: engine.View{St: uint16(vals), End: uint16(end)},
+		}
+		req.Tcount++
+		st = lf + 1
+	}
+	return n, nil
+}
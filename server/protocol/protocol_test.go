@@ -1,7 +1,12 @@
 package protocol
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/s00inx/goserver/server/engine"
@@ -10,11 +15,28 @@ import (
 func BenchmarkBuildResp(b *testing.B) {
 	body := []byte("{\"status\":\"ok\",\"message\":\"hello world\"}")
 	dst := make([]byte, 1024)
+	date := []byte("Mon, 19 Oct 2026 10:00:00 UTC")
 
 	b.ReportAllocs()
 	b.ResetTimer()
 	for b.Loop() {
-		_ = BuildResp(200, []engine.Header{}, body, dst)
+		_ = BuildResp(200, []engine.Header{}, body, dst, date)
+	}
+}
+
+func TestBuildHeader(t *testing.T) {
+	body := []byte("hello")
+	date := []byte("Mon, 19 Oct 2026 10:00:00 UTC")
+	headers := []engine.Header{{Key: []byte("X-Test"), Val: []byte("1")}}
+
+	full := make([]byte, 512)
+	n := BuildResp(200, headers, body, full, date)
+
+	hdr := make([]byte, 512)
+	hn := BuildHeader(200, headers, len(body), hdr, date)
+
+	if got := string(hdr[:hn]) + string(body); got != string(full[:n]) {
+		t.Errorf("header + body differs from BuildResp:\n%q\n%q", got, full[:n])
 	}
 }
 
@@ -35,13 +57,13 @@ func BenchmarkParse(b *testing.B) {
 	b.ResetTimer()
 
 	for b.Loop() {
-		_, _ = p.parseRaw(raw, hbuf, req)
+		_, _ = p.parseRaw(raw, hbuf, req, nil)
 	}
 }
 
 func BenchmarkParseHeavy(b *testing.B) {
 	headers := ""
-	for i := range 20 {
+	for i := range 13 { // 3 more below, Hbuf has 16 slots
 		headers += fmt.Sprintf("X-Header-%d: value-%d-extra-long-data-for-stress-test\r\n", i, i)
 	}
 	body := make([]byte, 1024)
@@ -172,3 +194,392 @@ func TestHTTPParser_Parse(t *testing.T) {
 		}
 	})
 }
+
+func newProxySession(raw []byte) *engine.Session {
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	s.Offset = uint32(copy(s.Buf, raw))
+	return s
+}
+
+func TestProxyParser_Parse(t *testing.T) {
+	httpReq := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	t.Run("V1 TCP4", func(t *testing.T) {
+		p := &ProxyParser{Mode: ProxyRequired}
+		s := newProxySession([]byte("PROXY TCP4 10.0.0.7 192.168.0.1 5555 80\r\n" + httpReq))
+
+		ok, err := p.Parse(s)
+		if !ok || err != nil {
+			t.Fatalf("expected ok, got %v %v", ok, err)
+		}
+		if want := netip.MustParseAddrPort("10.0.0.7:5555"); s.Remote != want {
+			t.Errorf("expected remote %v, got %v", want, s.Remote)
+		}
+		if string(s.Buf[:s.Offset]) != httpReq {
+			t.Errorf("header was not cut: %q", s.Buf[:s.Offset])
+		}
+	})
+
+	t.Run("V1 Incomplete", func(t *testing.T) {
+		p := &ProxyParser{Mode: ProxyRequired}
+		s := newProxySession([]byte("PROXY TCP6 2001:db8::1 "))
+
+		ok, err := p.Parse(s)
+		if ok || err != nil {
+			t.Fatalf("expected wait for data, got %v %v", ok, err)
+		}
+	})
+
+	t.Run("V2 TCP4 With TLVs", func(t *testing.T) {
+		tlvs := []byte{ProxyTLVAuthority, 0, 11}
+		tlvs = append(tlvs, "example.com"...)
+		tlvs = append(tlvs, ProxyTLVCRC32C, 0, 4, 0, 0, 0, 0)
+
+		hdr := append([]byte{}, proxyV2Sig...)
+		hdr = append(hdr, 0x21, 0x11, 0, byte(12+len(tlvs)))
+		hdr = append(hdr, 203, 0, 113, 9, 127, 0, 0, 1, 0x1f, 0x90, 0, 80)
+		hdr = append(hdr, tlvs...)
+		sum := crc32.Checksum(hdr, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(hdr[len(hdr)-4:], sum)
+
+		var authority string
+		p := &ProxyParser{Mode: ProxyOptional, OnTLV: func(s *engine.Session, typ byte, val []byte) {
+			if typ == ProxyTLVAuthority {
+				authority = string(val)
+			}
+		}}
+		s := newProxySession(append(hdr, httpReq...))
+
+		ok, err := p.Parse(s)
+		if !ok || err != nil {
+			t.Fatalf("expected ok, got %v %v", ok, err)
+		}
+		if want := netip.MustParseAddrPort("203.0.113.9:8080"); s.Remote != want {
+			t.Errorf("expected remote %v, got %v", want, s.Remote)
+		}
+		if authority != "example.com" {
+			t.Errorf("expected authority TLV, got %q", authority)
+		}
+		if string(s.Buf[:s.Offset]) != httpReq {
+			t.Errorf("header was not cut: %q", s.Buf[:s.Offset])
+		}
+
+		// broken checksum must be rejected
+		hdr[len(hdr)-1]++
+		s = newProxySession(hdr)
+		if _, err := (&ProxyParser{Mode: ProxyOptional}).Parse(s); err == nil {
+			t.Error("expected crc32c error")
+		}
+	})
+
+	t.Run("Optional Without Header", func(t *testing.T) {
+		p := &ProxyParser{Mode: ProxyOptional}
+		s := newProxySession([]byte(httpReq))
+
+		ok, err := p.Parse(s)
+		if !ok || err != nil {
+			t.Fatalf("expected plain HTTP, got %v %v", ok, err)
+		}
+		if string(s.Buf[:s.Offset]) != httpReq {
+			t.Errorf("buffer changed: %q", s.Buf[:s.Offset])
+		}
+	})
+
+	t.Run("Required Without Header", func(t *testing.T) {
+		p := &ProxyParser{Mode: ProxyRequired}
+		s := newProxySession([]byte(httpReq))
+
+		if _, err := p.Parse(s); err == nil {
+			t.Error("expected error for missing proxy header")
+		}
+	})
+}
+
+func TestHTTPParser_Limits(t *testing.T) {
+	parser := &HTTPParser{Limits: Limits{MaxURI: 64, MaxHeaderBytes: 256, MaxBody: 100}}
+
+	manyHeaders := ""
+	for i := range 20 {
+		manyHeaders += fmt.Sprintf("X-H%d: v\r\n", i)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		code int
+	}{
+		{"Bare LF", "GET / HTTP/1.1\nHost: x\r\n\r\n", 400},
+		{"Garbage Method", strings.Repeat("A", 64), 400},
+		{"Long URI", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n", 414},
+		{"Long URI Incomplete", "GET /" + strings.Repeat("a", 100), 414},
+		{"Too Many Headers", "GET / HTTP/1.1\r\n" + manyHeaders + "\r\n", 431},
+		{"Header Block Too Large", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("b", 300) + "\r\n\r\n", 431},
+		{"Body Too Large", "POST / HTTP/1.1\r\nContent-Length: 101\r\n\r\n", 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &engine.Session{Buf: make([]byte, 1024)}
+			s.Offset = uint32(copy(s.Buf, tt.raw))
+
+			_, err := parser.Parse(s, func(*engine.Session, []byte) {
+				t.Error("callback should not be called")
+			})
+			if code := StatusCode(err); code != tt.code {
+				t.Errorf("expected %d, got %d (%v)", tt.code, code, err)
+			}
+		})
+	}
+
+	t.Run("Body Bigger Than Buffer", func(t *testing.T) {
+		s := &engine.Session{Buf: make([]byte, 128)}
+		s.Offset = uint32(copy(s.Buf, "POST / HTTP/1.1\r\nContent-Length: 99\r\n\r\n"))
+
+		_, err := (&HTTPParser{}).Parse(s, func(*engine.Session, []byte) {})
+		if StatusCode(err) != 413 {
+			t.Errorf("expected 413, got %v", err)
+		}
+	})
+}
+
+func TestBuildHeader_NoBodyCodes(t *testing.T) {
+	dst := make([]byte, 512)
+	for _, code := range []int{101, 204, 304} {
+		n := BuildHeader(code, nil, 0, dst, nil)
+		if bytes.Contains(dst[:n], []byte("Content-Length")) {
+			t.Errorf("%d: unexpected Content-Length in %q", code, dst[:n])
+		}
+	}
+}
+
+func TestHTTPParser_Connection(t *testing.T) {
+	p := &HTTPParser{}
+	tests := []struct {
+		req  string
+		conn uint8
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", engine.ConnKeepAlive},
+		{"GET / HTTP/1.1\r\nconnection: Close\r\n\r\n", engine.ConnClose},
+		{"GET / HTTP/1.1\r\nConnection: keep-alive\r\n\r\n", engine.ConnKeepAlive},
+		{"GET / HTTP/1.0\r\n\r\n", engine.ConnClose},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", engine.ConnKeepAlive10},
+		{"GET / HTTP/1.0\r\nConnection: keep-alive, close\r\n\r\n", engine.ConnClose},
+	}
+
+	hbuf := make([]engine.HeaderView, 16)
+	for _, tt := range tests {
+		var req engine.RawRequest
+		if _, err := p.parseRaw([]byte(tt.req), hbuf, &req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if req.Conn != tt.conn {
+			t.Errorf("%q: expected conn %d, got %d", tt.req, tt.conn, req.Conn)
+		}
+	}
+}
+
+func TestChunkedDecoder(t *testing.T) {
+	raw := "4;name=val\r\nWiki\r\n5\r\npedia\r\nE\r\n in\r\n\r\nchunks.\r\n0\r\nX-Sum: 1\r\n\r\nGET"
+	want := "Wikipedia in\r\n\r\nchunks."
+
+	// byte by byte: decoder keeps state between calls
+	var d ChunkedDecoder
+	var out []byte
+	consumed := 0
+	for i := 0; i < len(raw) && !d.Done(); i++ {
+		var b [1]byte
+		nw, nr, err := d.Decode(b[:], []byte{raw[i]})
+		if err != nil {
+			t.Fatalf("byte %d: %v", i, err)
+		}
+		out = append(out, b[:nw]...)
+		consumed += nr
+	}
+	if !d.Done() || string(out) != want || raw[consumed:] != "GET" {
+		t.Fatalf("done=%v out=%q rest=%q", d.Done(), out, raw[consumed:])
+	}
+	if tr := raw[d.TrailerAt():consumed]; tr != "X-Sum: 1\r\n\r\n" {
+		t.Errorf("bad trailer section %q", tr)
+	}
+
+	// in place
+	buf := []byte(raw)
+	var d2 ChunkedDecoder
+	nw, nr, err := d2.Decode(buf, buf)
+	if err != nil || string(buf[:nw]) != want || nr != consumed {
+		t.Errorf("in place: %q %d %v", buf[:nw], nr, err)
+	}
+
+	for _, bad := range []string{"\r\n", "x\r\n", "4\r\nWikiX\r\n", "4\nWiki\r\n", "1234567890abcdef0\r\n", "0\r\nX: 1\n"} {
+		var d ChunkedDecoder
+		if _, _, err := d.Decode(nil, []byte(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestHTTPParser_Chunked(t *testing.T) {
+	p := &HTTPParser{}
+	req := "POST /up HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n6;ext\r\ndefghi\r\n0\r\nChecksum: 42\r\n\r\n"
+	raw := req + "GET /next HTTP/1.1\r\n\r\n"
+
+	// fed byte by byte: decoder state stays on session, so every byte is decoded once
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	hlen := strings.Index(req, "\r\n\r\n") + 4
+	var bodies, trailers, paths []string
+	for i := range raw {
+		s.Buf[s.Offset] = raw[i]
+		s.Offset++
+		_, err := p.Parse(s, func(s *engine.Session, buf []byte) {
+			paths = append(paths, string(s.Req.Path.AsBuf(s)))
+			bodies = append(bodies, string(s.Req.Body.AsBuf(s)))
+			if s.Req.Tcount == 1 {
+				h := s.Hbuf[s.Req.Hcount]
+				trailers = append(trailers, string(h.Key.AsBuf(s))+"="+string(h.Val.AsBuf(s)))
+			}
+			if len(paths) == 1 && len(buf) != len(req) {
+				t.Errorf("consumed %d, expected %d", len(buf), len(req))
+			}
+		})
+		if err != nil {
+			t.Fatalf("byte %d: %v", i, err)
+		}
+		if len(paths) == 0 && i >= hlen && s.Chunk.Read != i+1-hlen {
+			t.Fatalf("byte %d: decoder consumed %d body bytes, expected %d", i, s.Chunk.Read, i+1-hlen)
+		}
+	}
+	if strings.Join(paths, " ") != "/up /next" || bodies[0] != "abcdefghi" || bodies[1] != "" {
+		t.Fatalf("paths %v, bodies %q", paths, bodies)
+	}
+	if len(trailers) != 1 || trailers[0] != "Checksum=42" {
+		t.Errorf("trailers %v", trailers)
+	}
+
+	errs := []struct {
+		raw  string
+		code int
+	}{
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", 400},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", 400},
+	}
+	for _, tt := range errs {
+		var req engine.RawRequest
+		_, err := p.parseRaw([]byte(tt.raw), make([]engine.HeaderView, 16), &req, nil)
+		if code := StatusCode(err); code != tt.code {
+			t.Errorf("%q: expected %d, got %v", tt.raw, tt.code, err)
+		}
+	}
+
+	lim := &HTTPParser{Limits: Limits{MaxBody: 4}}
+	var r engine.RawRequest
+	if _, err := lim.parseRaw([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello"), make([]engine.HeaderView, 16), &r, nil); StatusCode(err) != 413 {
+		t.Errorf("expected 413, got %v", err)
+	}
+}
+
+func TestHTTPParser_Expect(t *testing.T) {
+	p := &HTTPParser{}
+	tests := []struct {
+		raw string
+		err error
+	}{
+		{"POST / HTTP/1.1\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\n", errExpect},
+		{"POST / HTTP/1.1\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n", errExpect},
+		{"POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello", nil},
+		{"POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n", errIncomplete},
+		{"POST / HTTP/1.1\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\n", ErrExpectation},
+	}
+	for _, tt := range tests {
+		var req engine.RawRequest
+		buf := make([]byte, len(tt.raw), 1024)
+		copy(buf, tt.raw)
+		if _, err := p.parseRaw(buf, make([]engine.HeaderView, 16), &req, nil); err != tt.err {
+			t.Errorf("%q: expected %v, got %v", tt.raw, tt.err, err)
+		}
+	}
+}
+
+func TestHTTPParser_ExpectHookCode(t *testing.T) {
+	// hook codes that can't be final error response become 417
+	for code, want := range map[int]int{401: 401, 413: 413, 0: 417, 200: 417, 299: 417, 302: 417, 418: 417, 600: 417} {
+		p := &HTTPParser{Expect: func(*engine.Session) int { return code }}
+		if got := StatusCode(p.expect(&engine.Session{})); got != want {
+			t.Errorf("hook %d: expected %d, got %d", code, want, got)
+		}
+	}
+}
+
+func TestHTTPParser_Strict(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		strict  error
+		lenient error
+	}{
+		{"Duplicate CL", "POST / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 1\r\n\r\na", errInvalid, nil},
+		{"Different CL", "POST / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab", errInvalid, errInvalid},
+		{"CL List", "POST / HTTP/1.1\r\nContent-Length: 1, 1\r\n\r\na", errInvalid, nil},
+		{"CL Sign", "POST / HTTP/1.1\r\nContent-Length: +1\r\n\r\na", errInvalid, errInvalid},
+		{"CL Garbage", "POST / HTTP/1.1\r\nContent-Length: 1x0\r\n\r\na", errInvalid, errInvalid},
+		{"CL Empty", "POST / HTTP/1.1\r\nContent-Length: \r\n\r\n", errInvalid, errInvalid},
+		{"CL Whitespace", "POST / HTTP/1.1\r\nContent-Length:  1 \r\n\r\na", nil, nil},
+		{"CL Overflow", "POST / HTTP/1.1\r\nContent-Length: 99999999999999999999999\r\n\r\n", ErrPayloadTooLarge, ErrPayloadTooLarge},
+		{"CL And TE", "POST / HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", errInvalid, nil},
+		{"Method Token", "G(T / HTTP/1.1\r\n\r\n", errInvalid, nil},
+		{"Space Before Colon", "GET / HTTP/1.1\r\nHost : x\r\n\r\n", errInvalid, nil},
+		{"Obs Fold", "GET / HTTP/1.1\r\nX-A: 1\r\n  2\r\n\r\n", errInvalid, errInvalid},
+		{"Header Name Token", "GET / HTTP/1.1\r\nX-\x01: 1\r\n\r\n", errInvalid, nil},
+		{"Trailer Name Token", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-\x01: 1\r\n\r\n", errInvalid, nil},
+		{"Trailer Space Before Colon", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Sum : 1\r\n\r\n", errInvalid, nil},
+		{"Trailer Obs Fold", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-A: 1\r\n  b: 2\r\n\r\n", errInvalid, nil},
+		{"Trailer Valid", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Sum: 1\r\n\r\n", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range []*HTTPParser{{}, {Lenient: true}} {
+				want := tt.strict
+				if p.Lenient {
+					want = tt.lenient
+				}
+				var req engine.RawRequest
+				buf := make([]byte, len(tt.raw), 1024)
+				copy(buf, tt.raw)
+				if _, err := p.parseRaw(buf, make([]engine.HeaderView, 16), &req, nil); err != want {
+					t.Errorf("lenient=%v: expected %v, got %v", p.Lenient, want, err)
+				}
+				if tt.name == "CL And TE" && p.Lenient && req.Conn != engine.ConnClose {
+					t.Error("lenient CL+TE request must close conn")
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPParser_KnownHeaders(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nhost: a\r\nX-Other: 1\r\nCONTENT-TYPE: text/plain\r\nCookie: c=1\r\nAuthorization: Bearer t\r\n" +
+		"Accept-Encoding: gzip\r\nHost: second\r\nConnection: keep-alive\r\n\r\n"
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	s.Offset = uint32(copy(s.Buf, raw))
+
+	want := map[int]string{
+		engine.HHost:             "a", // first one wins
+		engine.HContentType:      "text/plain",
+		engine.HConnection:       "keep-alive",
+		engine.HAcceptEncoding:   "gzip",
+		engine.HAuthorization:    "Bearer t",
+		engine.HCookie:           "c=1",
+		engine.HTransferEncoding: "",
+	}
+	(&HTTPParser{}).Parse(s, func(s *engine.Session, buf []byte) {
+		for k, v := range want {
+			if got := string(s.KnownHeader(k)); got != v {
+				t.Errorf("slot %d: expected %q, got %q", k, v, got)
+			}
+		}
+	})
+	if engine.KnownHeader([]byte("Hostx")) != -1 || engine.KnownHeader([]byte("hOST")) != engine.HHost {
+		t.Error("bad KnownHeader")
+	}
+}
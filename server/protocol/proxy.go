@@ -0,0 +1,294 @@
+// HAProxy PROXY protocol (v1 text and v2 binary) parser,
+// it runs before HTTPParser and replaces Session.Remote with real client address
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net/netip"
+
+	"github.com/s00inx/goserver/server/engine"
+)
+
+// how listener treats PROXY headers
+type ProxyMode uint8
+
+const (
+	ProxyOff      ProxyMode = iota // no PROXY protocol, stream is plain HTTP
+	ProxyOptional                  // accept PROXY header if it is present
+	ProxyRequired                  // every conn must start with PROXY header
+)
+
+// TLV types from PROXY v2 spec
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+const (
+	proxyV1Max  = 107 // max length of v1 header including CRLF
+	proxyV2Head = 16  // signature + ver/cmd + fam + len
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// callback for v2 TLVs, val refers to session buffer and valid only during call
+type ProxyTLVFunc func(s *engine.Session, typ byte, val []byte)
+
+// PROXY protocol parser, should be init in server.go (like HTTPParser)
+type ProxyParser struct {
+	Mode  ProxyMode
+	OnTLV ProxyTLVFunc
+}
+
+// consume PROXY header from the start of session buffer;
+// returns true when the stream is ready for HTTPParser (header consumed or not expected)
+func (p *ProxyParser) Parse(s *engine.Session) (bool, error) {
+	if p.Mode == ProxyOff || s.Flags&engine.FlagProxied != 0 {
+		return true, nil
+	}
+
+	raw := s.Buf[:s.Offset]
+	if len(raw) == 0 {
+		return false, nil
+	}
+
+	var (
+		n   int
+		err error
+	)
+
+	switch {
+	case hasPrefixPart(raw, proxyV2Sig):
+		if len(raw) < len(proxyV2Sig) {
+			return false, nil
+		}
+		n, err = p.parseV2(s, raw)
+	case hasPrefixPart(raw, proxyV1Sig):
+		if len(raw) < len(proxyV1Sig) {
+			return false, nil
+		}
+		n, err = p.parseV1(s, raw)
+	default:
+		if p.Mode == ProxyRequired {
+			return false, errProxyInvalid
+		}
+		s.Flags |= engine.FlagProxied
+		return true, nil
+	}
+
+	if err != nil {
+		if err == errIncomplete {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// cut header so HTTPParser sees request at Buf[0]
+	rem := int(s.Offset) - n
+	if rem > 0 {
+		copy(s.Buf, s.Buf[n:s.Offset])
+	}
+	s.Offset = uint32(rem)
+	s.Flags |= engine.FlagProxied
+
+	return true, nil
+}
+
+// PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\n
+func (p *ProxyParser) parseV1(s *engine.Session, raw []byte) (int, error) {
+	lim := min(len(raw), proxyV1Max)
+	lf := bytes.IndexByte(raw[:lim], '\n')
+	if lf == -1 {
+		if len(raw) >= proxyV1Max {
+			return 0, errProxyInvalid
+		}
+		return 0, errIncomplete
+	}
+	if raw[lf-1] != '\r' {
+		return 0, errProxyInvalid
+	}
+
+	line := raw[len(proxyV1Sig) : lf-1]
+	fam, line, _ := bytes.Cut(line, []byte{' '})
+
+	var v4 bool
+	switch string(fam) {
+	case "UNKNOWN":
+		// proxy could not detect the client, keep socket peer
+		return lf + 1, nil
+	case "TCP4":
+		v4 = true
+	case "TCP6":
+	default:
+		return 0, errProxyInvalid
+	}
+
+	var fields [4][]byte
+	for i := range fields {
+		var f []byte
+		f, line, _ = bytes.Cut(line, []byte{' '})
+		if len(f) == 0 {
+			return 0, errProxyInvalid
+		}
+		fields[i] = f
+	}
+	if len(line) != 0 {
+		return 0, errProxyInvalid
+	}
+
+	src, err := netip.ParseAddr(string(fields[0]))
+	if err != nil || src.Is4() != v4 || src.Zone() != "" {
+		return 0, errProxyInvalid
+	}
+	dst, err := netip.ParseAddr(string(fields[1]))
+	if err != nil || dst.Is4() != v4 {
+		return 0, errProxyInvalid
+	}
+	sport, ok := parsePort(fields[2])
+	if !ok {
+		return 0, errProxyInvalid
+	}
+	if _, ok := parsePort(fields[3]); !ok {
+		return 0, errProxyInvalid
+	}
+
+	s.Remote = netip.AddrPortFrom(src, sport)
+	return lf + 1, nil
+}
+
+// binary header: sig(12) ver_cmd(1) fam(1) len(2) addrs tlvs
+func (p *ProxyParser) parseV2(s *engine.Session, raw []byte) (int, error) {
+	if len(raw) < proxyV2Head {
+		return 0, errIncomplete
+	}
+
+	vercmd, fam := raw[12], raw[13]
+	if vercmd>>4 != 2 {
+		return 0, errProxyInvalid
+	}
+	cmd := vercmd & 0x0f
+	if cmd > 1 {
+		return 0, errProxyInvalid
+	}
+
+	total := proxyV2Head + int(binary.BigEndian.Uint16(raw[14:16]))
+	if total > len(s.Buf) {
+		return 0, errProxyInvalid
+	}
+	if len(raw) < total {
+		return 0, errIncomplete
+	}
+	hdr := raw[:total]
+	body := hdr[proxyV2Head:]
+
+	var (
+		remote netip.AddrPort
+		alen   int
+	)
+	switch fam >> 4 {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		alen = 12
+		if len(body) < alen {
+			return 0, errProxyInvalid
+		}
+		remote = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:10]))
+	case 0x2: // AF_INET6
+		alen = 36
+		if len(body) < alen {
+			return 0, errProxyInvalid
+		}
+		remote = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[0:16])), binary.BigEndian.Uint16(body[32:34]))
+	case 0x3: // AF_UNIX
+		alen = 216
+		if len(body) < alen {
+			return 0, errProxyInvalid
+		}
+	default:
+		return 0, errProxyInvalid
+	}
+
+	if err := p.walkTLV(s, hdr, proxyV2Head+alen); err != nil {
+		return 0, err
+	}
+
+	// LOCAL command means health check from proxy itself, keep socket peer
+	if cmd == 1 && remote.IsValid() {
+		s.Remote = remote
+	}
+	return total, nil
+}
+
+// validate TLV region of v2 header, check crc32c if present and call OnTLV
+func (p *ProxyParser) walkTLV(s *engine.Session, hdr []byte, off int) error {
+	for i := off; i < len(hdr); {
+		if i+3 > len(hdr) {
+			return errProxyInvalid
+		}
+		typ := hdr[i]
+		vlen := int(binary.BigEndian.Uint16(hdr[i+1 : i+3]))
+		vs := i + 3
+		if vs+vlen > len(hdr) {
+			return errProxyInvalid
+		}
+
+		if typ == ProxyTLVCRC32C {
+			if vlen != 4 {
+				return errProxyInvalid
+			}
+			// checksum is computed over whole header with crc field set to zero
+			var zero [4]byte
+			sum := crc32.Update(0, crc32c, hdr[:vs])
+			sum = crc32.Update(sum, crc32c, zero[:])
+			sum = crc32.Update(sum, crc32c, hdr[vs+4:])
+			if sum != binary.BigEndian.Uint32(hdr[vs:vs+4]) {
+				return errProxyInvalid
+			}
+		}
+
+		if p.OnTLV != nil {
+			p.OnTLV(s, typ, hdr[vs:vs+vlen])
+		}
+		i = vs + vlen
+	}
+	return nil
+}
+
+// check that b starts with sig or is a (not yet complete) prefix of it
+func hasPrefixPart(b, sig []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	n := min(len(b), len(sig))
+	return bytes.Equal(b[:n], sig[:n])
+}
+
+// decimal port without sign, 0..65535
+func parsePort(b []byte) (uint16, bool) {
+	if len(b) == 0 || len(b) > 5 || (len(b) > 1 && b[0] == '0') {
+		return 0, false
+	}
+	var n uint32
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + uint32(c-'0')
+	}
+	if n > 65535 {
+		return 0, false
+	}
+	return uint16(n), true
+}
@@ -0,0 +1,29 @@
+package protocol
+
+// tchar of RFC 9110 5.6.2: methods and header names are tokens
+var tokenTable = [256]bool{
+	'!': true, '#': true, '$': true, '%': true, '&': true, '\'': true, '*': true,
+	'+': true, '-': true, '.': true, '^': true, '_': true, '`': true, '|': true, '~': true,
+}
+
+func init() {
+	for c := '0'; c <= '9'; c++ {
+		tokenTable[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		tokenTable[c] = true
+		tokenTable[c-'a'+'A'] = true
+	}
+}
+
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if !tokenTable[c] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,372 @@
+// struct binding: c.Bind(&v) fills fields by tags path, query, header, form and json (body),
+// field list of every type is built with reflect once and cached
+package router
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBindTarget = errors.New("bind: target must be pointer to struct")
+
+// value sources, also tag names
+const (
+	srcPath uint8 = iota
+	srcQuery
+	srcHeader
+	srcForm
+	srcCount
+)
+
+var srcNames = [srcCount]string{"path", "query", "header", "form"}
+
+// one field that can't be bound, Source is tag name (json for body errors)
+type FieldError struct {
+	Field  string // struct field name (dotted for nested json fields)
+	Source string
+	Key    string // name in source
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return "bind " + e.Source + " " + strconv.Quote(e.Key) + " to " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// all field errors of one Bind call
+type BindErrors []*FieldError
+
+func (e BindErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// {"errors":[{"field","source","key","message"}...]}, body of 400 answer
+func (e BindErrors) MarshalJSON() ([]byte, error) {
+	type fieldJSON struct {
+		Field   string `json:"field"`
+		Source  string `json:"source"`
+		Key     string `json:"key,omitempty"`
+		Message string `json:"message"`
+	}
+	out := struct {
+		Errors []fieldJSON `json:"errors"`
+	}{make([]fieldJSON, len(e))}
+	for i, fe := range e {
+		out.Errors[i] = fieldJSON{Field: fe.Field, Source: fe.Source, Key: fe.Key, Message: fe.Err.Error()}
+	}
+	return json.Marshal(out)
+}
+
+// cached binding metadata of struct type
+type bindMeta struct {
+	fields [srcCount][]bindField
+	json   bool // type has json tags (or no tags at all), body is decoded into it
+}
+
+type bindField struct {
+	index []int
+	name  string
+	key   []byte
+	slice bool // repeated values (?id=1&id=2) go to slice
+}
+
+var bindCache sync.Map // reflect.Type -> *bindMeta
+
+var (
+	textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType    = reflect.TypeFor[time.Duration]()
+)
+
+// fill struct from every source: json body first (if Content-Type is json), then path, query, header, form;
+// errors of all fields are collected into BindErrors, multipart body that can't be parsed
+// fails with its protocol.StatusError (ErrBadMultipart...)
+func (c *Context) Bind(v any) error {
+	return c.bind(v, true, srcPath, srcQuery, srcHeader, srcForm)
+}
+
+// json body only, Content-Type is not checked
+func (c *Context) BindJSON(v any) error { return c.bind(v, true) }
+
+func (c *Context) BindPath(v any) error   { return c.bind(v, false, srcPath) }
+func (c *Context) BindQuery(v any) error  { return c.bind(v, false, srcQuery) }
+func (c *Context) BindHeader(v any) error { return c.bind(v, false, srcHeader) }
+func (c *Context) BindForm(v any) error   { return c.bind(v, false, srcForm) }
+
+var (
+	mtJSON    = []byte("application/json")
+	jsonSufix = []byte("+json")
+)
+
+func (c *Context) bind(v any, body bool, srcs ...uint8) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	rv = rv.Elem()
+	m := metaOf(rv.Type())
+
+	var errs BindErrors
+	// Bind decodes body only into types with json fields, BindJSON always
+	if body && (len(srcs) == 0 || m.json && c.isJSON()) && len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), v); err != nil {
+			fe := &FieldError{Source: "json", Err: err}
+			var te *json.UnmarshalTypeError
+			if errors.As(err, &te) {
+				fe.Field, fe.Key = te.Field, te.Field
+			}
+			errs = append(errs, fe)
+		}
+	}
+
+	var buf [256]byte
+	var vals [16][]byte
+	for _, src := range srcs {
+		// body that can't be parsed fails whole bind with its status, fields are not "missing"
+		if src == srcForm && len(m.fields[srcForm]) > 0 {
+			if err := c.formError(); err != nil {
+				return err
+			}
+		}
+		for i := range m.fields[src] {
+			f := &m.fields[src][i]
+			raw := c.lookup(src, f, buf[:], vals[:0])
+			if raw == nil {
+				continue
+			}
+			fv := rv.FieldByIndex(f.index)
+			if err := setField(fv, raw, f.slice); err != nil {
+				errs = append(errs, &FieldError{Field: f.name, Source: srcNames[src], Key: string(f.key), Err: err})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// values of field in source, nil if there are none; single value is decoded into buf
+func (c *Context) lookup(src uint8, f *bindField, buf []byte, vals [][]byte) [][]byte {
+	var v []byte
+	switch src {
+	case srcPath:
+		v = c.ParamDecoded(f.key, buf)
+		if v == nil {
+			return nil
+		}
+	case srcHeader:
+		v = c.Header(f.key)
+		if v == nil {
+			return nil
+		}
+	case srcQuery:
+		if f.slice {
+			vals = c.QueryAll(f.key, vals)
+			for i, raw := range vals {
+				vals[i] = appendUnescape(nil, raw, true)
+			}
+			return nilIfEmpty(vals)
+		}
+		var ok bool
+		if v, ok = c.queryDecoded(f.key, buf); !ok {
+			return nil
+		}
+	case srcForm:
+		if f.slice {
+			return nilIfEmpty(c.formAll(f.key, vals))
+		}
+		v = c.FormValue(f.key, buf)
+		if v == nil {
+			return nil
+		}
+	}
+	return append(vals, v)
+}
+
+// error of multipart body, nil for urlencoded and other content types
+func (c *Context) formError() error {
+	if c.isMedia(mtURLEncoded) {
+		return nil
+	}
+	if _, err := c.implicitForm(); err != ErrNotMultipart {
+		return err
+	}
+	return nil
+}
+
+// all decoded values of form field
+func (c *Context) formAll(key []byte, vals [][]byte) [][]byte {
+	if c.isMedia(mtURLEncoded) {
+		for k, v := range c.FormPairs() {
+			if unescapedEqual(k, key) {
+				vals = append(vals, appendUnescape(nil, v, true))
+			}
+		}
+		return vals
+	}
+	f, err := c.MultipartForm(DefaultMaxMemory)
+	if err != nil {
+		return vals
+	}
+	for i := range f.Parts {
+		if p := &f.Parts[i]; len(p.Filename) == 0 && bytes.Equal(p.Name, key) {
+			vals = append(vals, p.data)
+		}
+	}
+	return vals
+}
+
+func nilIfEmpty(v [][]byte) [][]byte {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (c *Context) isJSON() bool {
+	ct := c.ContentType()
+	if i := bytes.IndexByte(ct, ';'); i != -1 {
+		ct = ct[:i]
+	}
+	ct = bytes.TrimSpace(ct)
+	return bytes.EqualFold(ct, mtJSON) || len(ct) > len(jsonSufix) && bytes.EqualFold(ct[len(ct)-len(jsonSufix):], jsonSufix)
+}
+
+// metadata of type from cache, built on first use
+func metaOf(t reflect.Type) *bindMeta {
+	if m, ok := bindCache.Load(t); ok {
+		return m.(*bindMeta)
+	}
+	m := &bindMeta{}
+	tagged := false
+	m.collect(t, nil, &tagged)
+	if !tagged {
+		m.json = true // plain struct, json uses field names
+	}
+	actual, _ := bindCache.LoadOrStore(t, m)
+	return actual.(*bindMeta)
+}
+
+func (m *bindMeta) collect(t reflect.Type, index []int, tagged *bool) {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+
+		// embedded struct without tags: its fields are ours
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag == "" {
+			m.collect(sf.Type, idx, tagged)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if _, ok := sf.Tag.Lookup("json"); ok {
+			m.json, *tagged = true, true
+		}
+		for src := range srcCount {
+			key, ok := sf.Tag.Lookup(srcNames[src])
+			if !ok {
+				continue
+			}
+			*tagged = true
+			key, _, _ = strings.Cut(key, ",")
+			if key == "-" {
+				continue
+			}
+			if key == "" {
+				key = sf.Name
+			}
+			ft := sf.Type
+			slice := ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 && !reflect.PointerTo(ft).Implements(textUnmarshaler)
+			m.fields[src] = append(m.fields[src], bindField{index: idx, name: sf.Name, key: []byte(key), slice: slice})
+		}
+	}
+}
+
+// set field from raw values (one value unless field is slice)
+func setField(fv reflect.Value, raw [][]byte, slice bool) error {
+	if !slice {
+		return setValue(fv, raw[0])
+	}
+	s := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+	for i, r := range raw {
+		if err := setValue(s.Index(i), r); err != nil {
+			return err
+		}
+	}
+	fv.Set(s)
+	return nil
+}
+
+func setValue(fv reflect.Value, raw []byte) error {
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+	// time.Time (RFC 3339), netip.Addr and other text types
+	if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText(raw)
+	}
+
+	s := B2String(raw)
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(string(raw))
+	case reflect.Slice: // []byte, checked in collect
+		fv.SetBytes(bytes.Clone(raw))
+	case reflect.Bool:
+		if len(raw) == 0 {
+			fv.SetBool(true) // ?flag
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return errors.New("unsupported field type " + fv.Type().String())
+	}
+	return nil
+}
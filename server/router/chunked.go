@@ -0,0 +1,198 @@
+// streaming response: body is sent with Transfer-Encoding: chunked while handler writes it
+package router
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+var ErrWriterClosed = errors.New("chunked: response is finished")
+
+const chunkSize = 4 << 10 // writes are gathered up to this size before going to socket
+
+var (
+	hTEChunked = engine.Header{Key: []byte("Transfer-Encoding"), Val: []byte("chunked")}
+	http10     = []byte("HTTP/1.0")
+	crlf       = []byte("\r\n")
+)
+
+// streaming body of response, lives in Context and is used only by handler goroutine;
+// writes never wait for client: what socket doesn't take is queued on session and sent from event loop,
+// queue is bounded, so writer to client that doesn't read gets engine.ErrSlowPeer
+type ChunkedWriter struct {
+	c       *Context
+	buf     []byte // gathered body bytes, kept between requests
+	trailer []byte // encoded trailer fields
+	hdr     [32]byte
+	raw     bool // http/1.0 client doesn't know chunked, body ends with conn close
+	none    bool // status without body (1xx, 204, 304), writes are dropped
+	open    bool
+	sent    bool // chunk was sent, next size line goes after its CRLF
+	err     error
+}
+
+// start streaming response: header block is sent without Content-Length,
+// then body goes in chunks on Write/Flush; writer is finished by Close
+// or when handler returns. http2 streams can't be streamed this way,
+// ErrTooManyHeaders when there is no header slot left for Transfer-Encoding
+func (c *Context) Chunked(code int) (*ChunkedWriter, error) {
+	w := &c.cw
+	if w.open {
+		return w, nil
+	}
+	s := c.Session
+	if s.Responder != nil {
+		return nil, ErrNoStreaming
+	}
+
+	*w = ChunkedWriter{c: c, buf: w.buf[:0], trailer: w.trailer[:0], open: true}
+	switch {
+	case code < 200 || code == 204 || code == 304:
+		w.none = true
+	case bytes.Equal(s.Req.Protocol.AsBuf(s), http10):
+		w.raw = true
+		c.CloseConn()
+	default:
+		// without TE body has no framing on keep-alive conn
+		if int(c.hC) >= len(c.resH) {
+			w.open = false
+			return nil, ErrTooManyHeaders
+		}
+		c.SetHeader(hTEChunked.Key, hTEChunked.Val)
+	}
+	h := connHeaders(c.resH[:c.hC], s.Req.Conn)
+
+	// header is batched with pipelined responses, it goes out with first chunk
+	engine.WriteV(s, func(dst []byte) int {
+		return protocol.BuildHeader(code, h, -1, dst, s.Date())
+	}, nil)
+	return w, nil
+}
+
+// add body bytes, they are sent when chunk buffer is full (or on Flush);
+// big writes go to socket as is, without copy
+func (w *ChunkedWriter) Write(p []byte) (int, error) {
+	if !w.open {
+		return 0, ErrWriterClosed
+	}
+	if w.none {
+		return len(p), nil
+	}
+	if len(w.buf)+len(p) > chunkSize {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		if len(p) >= chunkSize {
+			if err := w.chunk(p); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// string version of Write
+func (w *ChunkedWriter) WriteString(s string) (int, error) {
+	return w.Write(S2Bytes(s))
+}
+
+// send gathered bytes to client now
+func (w *ChunkedWriter) Flush() error {
+	if !w.open {
+		return ErrWriterClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	err := w.chunk(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// add trailer field sent after last chunk (announce it with Trailer header if client needs to know),
+// ignored for http/1.0 clients
+func (w *ChunkedWriter) SetTrailer(key, val []byte) {
+	w.trailer = append(w.trailer, key...)
+	w.trailer = append(w.trailer, ": "...)
+	w.trailer = append(w.trailer, val...)
+	w.trailer = append(w.trailer, crlf...)
+}
+
+// send rest of body, last chunk and trailers; conn stays keep-alive for next request
+func (w *ChunkedWriter) Close() error {
+	if !w.open {
+		return nil
+	}
+	err := w.Flush()
+	if err == nil && !w.raw && !w.none {
+		err = w.last()
+	}
+	w.open = false
+	if err != nil {
+		// response is broken in the middle, conn can't be reused
+		w.c.CloseConn()
+	}
+	return err
+}
+
+// last chunk with trailer section
+func (w *ChunkedWriter) last() error {
+	s := w.c.Session
+	// header block is still batched if nothing was written
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	end := w.hdr[:0]
+	if w.sent {
+		end = append(end, crlf...)
+	}
+	end = append(end, '0', '\r', '\n')
+	w.trailer = append(w.trailer, crlf...)
+	_, err := s.WriteDirect(end, w.trailer)
+	return err
+}
+
+// write one chunk directly: size line goes together with CRLF of previous chunk,
+// so chunk data is never copied
+func (w *ChunkedWriter) chunk(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	s := w.c.Session
+	// pending header block and pipelined responses must go first
+	if err := s.Flush(); err != nil {
+		w.err = err
+		return err
+	}
+
+	hdr := w.hdr[:0]
+	if !w.raw {
+		if w.sent {
+			hdr = append(hdr, crlf...)
+		}
+		hdr = strconv.AppendInt(hdr, int64(len(p)), 16)
+		hdr = append(hdr, crlf...)
+	}
+	if _, err := s.WriteDirect(hdr, p); err != nil {
+		w.err = err
+		return err
+	}
+	w.sent = true
+	return nil
+}
+
+// finish response that handler left open and reset parsed form, removing its temp files (called by server after handlers)
+func (c *Context) End() {
+	if c.cw.open {
+		c.cw.Close()
+	}
+	if c.formParsed {
+		c.form.reset()
+	}
+}
@@ -3,7 +3,11 @@ package router
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"net/netip"
+	"strconv"
+	"time"
 	"unsafe"
 
 	"github.com/s00inx/goserver/server/engine"
@@ -14,7 +18,7 @@ import (
 type Handler func(c *Context)
 
 // Context is arena for session and Response buffers,
-// 808 bytes
+// 1056 bytes
 type Context struct {
 	Session  *engine.Session
 	resH     [16]engine.Header
@@ -22,6 +26,20 @@ type Context struct {
 	code     uint16
 	hC       uint8
 	chindex  uint8
+	cw       ChunkedWriter
+
+	// query split on first lookup, see query.go
+	qpairs  [maxQueryPairs]queryPair
+	qn      uint8
+	qparsed bool
+
+	cookieBuf []byte // serialized Set-Cookie values of response
+
+	// multipart form parsed on first use, see form.go
+	form         Form
+	formErr      error
+	formParsed   bool
+	formImplicit bool // parsed by FormValue, FormFile or Bind with DefaultMaxMemory
 }
 
 // unsafe AREA (i use unsafe bc []byte is not comfortable for business logic, so we need to convert String to Bytes w zero alloc)
@@ -59,34 +77,15 @@ func (c *Context) Query() []byte {
 	return c.Session.Req.RawQuery.AsBuf(c.Session)
 }
 
-// get url query value by key
+// get raw url query value by key (first one), see query.go for decoded and typed values
 func (c *Context) QueryGet(key []byte) []byte {
-	view := c.Session.Req.RawQuery
-	if view.End <= view.St {
-		return nil
-	}
-
-	q := view.AsBuf(c.Session)
-
-	for len(q) > 0 {
-		var pair []byte
-		idx := bytes.IndexByte(q, '&')
-
-		if idx == -1 {
-			pair = q
-			q = nil
-		} else {
-			pair = q[:idx]
-			q = q[idx+1:]
-		}
-
-		before, after, ok := bytes.Cut(pair, []byte{'='})
-		if ok && bytes.Equal(before, key) {
-			return after
-		}
-	}
+	v, _ := c.queryLookup(key)
+	return v
+}
 
-	return nil
+// get client address (real one if PROXY protocol is enabled)
+func (c *Context) RemoteAddr() netip.AddrPort {
+	return c.Session.Remote
 }
 
 func (c *Context) Protocol() []byte {
@@ -115,6 +114,16 @@ func (c *Context) Param(key []byte) []byte {
 	return nil
 }
 
+// param value with percent escapes decoded to dst (zero-copy view if there is nothing to decode),
+// so %2F kept by router is '/' here
+func (c *Context) ParamDecoded(key, dst []byte) []byte {
+	v := c.Param(key)
+	if bytes.IndexByte(v, '%') == -1 {
+		return v
+	}
+	return appendUnescape(dst[:0], v, false)
+}
+
 func (c *Context) ParamCount() int {
 	return int(c.Session.Req.Pcount)
 }
@@ -134,25 +143,48 @@ func (c *Context) Headers(buf []engine.Header) []engine.Header {
 	return buf[:count]
 }
 
-// get header by key
+// get header by key, names are case-insensitive (well-known ones are taken from parse-time index)
 func (c *Context) Header(key []byte) []byte {
+	if k := engine.KnownHeader(key); k >= 0 {
+		return c.Session.KnownHeader(k)
+	}
 	count := int(c.Session.Req.Hcount)
 
 	for i := range count {
 		h := &c.Session.Hbuf[i]
-		if bytes.Equal(h.Key.AsBuf(c.Session), key) {
+		if bytes.EqualFold(h.Key.AsBuf(c.Session), key) {
 			return h.Val.AsBuf(c.Session)
 		}
 	}
 	return nil
 }
 
-// Get request cookies
+// value of well-known header by slot (engine.HHost, engine.HContentType...), O(1)
+func (c *Context) KnownHeader(k int) []byte {
+	return c.Session.KnownHeader(k)
+}
+
+func (c *Context) Host() []byte        { return c.Session.KnownHeader(engine.HHost) }
+func (c *Context) ContentType() []byte { return c.Session.KnownHeader(engine.HContentType) }
+
+// get trailer field of chunked request body by key
+func (c *Context) Trailer(key []byte) []byte {
+	hc := int(c.Session.Req.Hcount)
+	for i := hc; i < hc+int(c.Session.Req.Tcount); i++ {
+		h := &c.Session.Hbuf[i]
+		if bytes.EqualFold(h.Key.AsBuf(c.Session), key) {
+			return h.Val.AsBuf(c.Session)
+		}
+	}
+	return nil
+}
+
+// Get raw Cookie header, see cookie.go for single cookies
 func (c *Context) GetCookies() []byte {
-	return c.Header(S2Bytes("Cookies"))
+	return c.Session.KnownHeader(engine.HCookie)
 }
 
-// context body as []byte (0 alloc)
+// context body as []byte (0 alloc), chunked body is already decoded
 func (c *Context) Body() []byte {
 	return c.Session.Req.Body.AsBuf(c.Session)
 }
@@ -170,14 +202,29 @@ func (c *Context) Reset(s *engine.Session, handlers []Handler) {
 
 	c.chindex = 0
 	c.handlers = handlers
+	c.qn, c.qparsed = 0, false
+	c.cookieBuf = c.cookieBuf[:0]
+	if c.formParsed {
+		c.form.reset()
+		c.formParsed, c.formImplicit, c.formErr = false, false, nil
+	}
 }
 
 // ! Context as Response Writer (setters)
 // helper func to send resp via engine method
+// only header block is built in pooled buf, body goes to writev as is (no copy, any size)
 func (c *Context) sendresp(co int, h []engine.Header, b []byte) {
-	engine.WriteBuf(c.Session, func(dst []byte) int {
-		return protocol.BuildResp(co, h, b, dst)
-	})
+	// http2 stream, response is framed by conn
+	if r := c.Session.Responder; r != nil {
+		r.Respond(c.Session, co, h, b)
+		return
+	}
+	if co >= 200 {
+		h = connHeaders(h, c.Session.Req.Conn)
+	}
+	engine.WriteV(c.Session, func(dst []byte) int {
+		return protocol.BuildHeader(co, h, len(b), dst, c.Session.Date())
+	}, b)
 }
 
 // set resp code
@@ -185,9 +232,12 @@ func (c *Context) SetCode(code int) {
 	c.code = uint16(code)
 }
 
-// set header with []byte key and val
+// response has 16 header slots, setters that can't lose their header (SetCookie, SSE, Chunked) return it
+var ErrTooManyHeaders = errors.New("router: no free response header slot")
+
+// set header with []byte key and val, dropped when all slots are taken
 func (c *Context) SetHeader(key, val []byte) {
-	if int(c.hC) > len(c.resH) {
+	if int(c.hC) >= len(c.resH) {
 		return
 	}
 	c.resH[c.hC] = engine.Header{Key: key, Val: val}
@@ -201,7 +251,7 @@ func (c *Context) SendDirect(code int, body []byte) {
 }
 
 func (c *Context) SendWithBody(body []byte) {
-	c.sendresp(int(c.code), c.resH[:], body)
+	c.sendresp(int(c.code), c.resH[:c.hC], body)
 }
 
 // Middleware functional
@@ -214,9 +264,34 @@ func (c *Context) Next() {
 
 // send error directly
 func (c *Context) Send404() {
-	engine.Write404(c.Session)
+	c.sendresp(404, textPlain[:], protocol.StatusText(404)[4:])
 }
 
 func (c *Context) Send500() {
-	engine.Write500(c.Session)
+	c.sendresp(500, textPlain[:], protocol.StatusText(500)[4:])
+}
+
+// close conn after this response (Connection: close is sent)
+func (c *Context) CloseConn() {
+	c.Session.Req.Conn = engine.ConnClose
+}
+
+var (
+	textPlain = [...]engine.Header{{Key: []byte("Content-Type"), Val: []byte("text/plain")}}
+
+	hConnClose     = engine.Header{Key: []byte("Connection"), Val: []byte("close")}
+	hConnKeepAlive = engine.Header{Key: []byte("Connection"), Val: []byte("keep-alive")}
+	hKeepAlive     = engine.Header{Key: []byte("Keep-Alive"), Val: []byte("timeout=" + strconv.Itoa(int(engine.IdleTimeout/time.Second)))}
+)
+
+// add Connection (and Keep-Alive) header for decision of parser,
+// h is usually Context.resH prefix so append doesn't alloc
+func connHeaders(h []engine.Header, conn uint8) []engine.Header {
+	switch conn {
+	case engine.ConnClose:
+		return append(h, hConnClose)
+	case engine.ConnKeepAlive10:
+		return append(h, hConnKeepAlive, hKeepAlive)
+	}
+	return h
 }
@@ -0,0 +1,185 @@
+// cookies: zero-alloc reading of Cookie header and Set-Cookie building (RFC 6265)
+package router
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/s00inx/goserver/server/engine"
+)
+
+var ErrInvalidCookie = errors.New("cookie: invalid name or value")
+
+type SameSite uint8
+
+const (
+	SameSiteDefault SameSite = iota // attribute is not sent
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// cookie for Set-Cookie, zero fields are not sent
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	MaxAge  int // seconds, negative deletes cookie now (Max-Age=0)
+
+	Secure      bool
+	HttpOnly    bool
+	Partitioned bool // CHIPS, browsers want Secure with it
+	SameSite    SameSite
+}
+
+var hSetCookie = []byte("Set-Cookie")
+
+const cookieTime = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// iterate request cookies in order (all Cookie headers, http2 sends one per cookie), 0 alloc
+func (c *Context) Cookies() iter.Seq2[[]byte, []byte] {
+	return func(yield func(name, val []byte) bool) {
+		s := c.Session
+		first := int(s.Req.Known[engine.HCookie])
+		if first == 0 {
+			return
+		}
+		for i := first - 1; i < int(s.Req.Hcount); i++ {
+			h := &s.Hbuf[i]
+			if i >= first && engine.KnownHeader(h.Key.AsBuf(s)) != engine.HCookie {
+				continue
+			}
+			for pair := range bytes.SplitSeq(h.Val.AsBuf(s), []byte{';'}) {
+				name, val, _ := bytes.Cut(bytes.TrimSpace(pair), []byte{'='})
+				if len(name) == 0 {
+					continue
+				}
+				// quoted value is allowed, quotes are not part of it
+				if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+					val = val[1 : len(val)-1]
+				}
+				if !yield(name, val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// value of request cookie by name (first one), nil if there is none
+func (c *Context) Cookie(name []byte) []byte {
+	for n, v := range c.Cookies() {
+		if bytes.Equal(n, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// add Set-Cookie header to response, every cookie is its own header;
+// serialized cookie lives in context buffer until response is sent
+func (c *Context) SetCookie(ck *Cookie) error {
+	if int(c.hC) >= len(c.resH) {
+		return ErrTooManyHeaders
+	}
+	st := len(c.cookieBuf)
+	b, err := AppendSetCookie(c.cookieBuf, ck)
+	if err != nil {
+		return err
+	}
+	c.cookieBuf = b
+	c.SetHeader(hSetCookie, b[st:len(b):len(b)])
+	return nil
+}
+
+// append Set-Cookie value of ck to dst
+func AppendSetCookie(dst []byte, ck *Cookie) ([]byte, error) {
+	if !validCookieName(ck.Name) || !validCookieValue(ck.Value) ||
+		!validCookieAttr(ck.Path) || !validCookieAttr(ck.Domain) {
+		return dst, ErrInvalidCookie
+	}
+
+	dst = append(dst, ck.Name...)
+	dst = append(dst, '=')
+	dst = append(dst, ck.Value...)
+	if ck.Path != "" {
+		dst = append(dst, "; Path="...)
+		dst = append(dst, ck.Path...)
+	}
+	if ck.Domain != "" {
+		dst = append(dst, "; Domain="...)
+		dst = append(dst, ck.Domain...)
+	}
+	if !ck.Expires.IsZero() {
+		dst = append(dst, "; Expires="...)
+		dst = ck.Expires.UTC().AppendFormat(dst, cookieTime)
+	}
+	switch {
+	case ck.MaxAge > 0:
+		dst = append(dst, "; Max-Age="...)
+		dst = strconv.AppendInt(dst, int64(ck.MaxAge), 10)
+	case ck.MaxAge < 0:
+		dst = append(dst, "; Max-Age=0"...)
+	}
+	if ck.Secure {
+		dst = append(dst, "; Secure"...)
+	}
+	if ck.HttpOnly {
+		dst = append(dst, "; HttpOnly"...)
+	}
+	switch ck.SameSite {
+	case SameSiteLax:
+		dst = append(dst, "; SameSite=Lax"...)
+	case SameSiteStrict:
+		dst = append(dst, "; SameSite=Strict"...)
+	case SameSiteNone:
+		dst = append(dst, "; SameSite=None"...)
+	}
+	if ck.Partitioned {
+		dst = append(dst, "; Partitioned"...)
+	}
+	return dst, nil
+}
+
+// cookie name is token
+func validCookieName(n string) bool {
+	if n == "" {
+		return false
+	}
+	for i := range len(n) {
+		c := n[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// cookie-octet: no controls, whitespace, DQUOTE, comma, semicolon, backslash
+func validCookieValue(v string) bool {
+	for i := range len(v) {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// attribute value can't break header or add attributes
+func validCookieAttr(v string) bool {
+	for i := range len(v) {
+		c := v[i]
+		if c < ' ' || c >= 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
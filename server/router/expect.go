@@ -0,0 +1,49 @@
+// Expect: 100-continue, route hook decides if body is wanted before client sends it
+package router
+
+import "github.com/s00inx/goserver/server/engine"
+
+// returns 100 to accept body or final status (417, 413, 401...) to reject request
+// (codes that are not 4xx/5xx with known text are sent as 417),
+// only request line, headers and params are available in context
+type ExpectFunc func(c *Context) int
+
+// set hook for requests with Expect: 100-continue on route, routes without hook accept any body
+func (g *RouteGroup) Expect(method, path string, f ExpectFunc) {
+	if g.rt.expect == nil {
+		g.rt.expect = &routes{}
+		g.rt.expect.init()
+	}
+	// hook is stored in its own trees as handler that puts decision to context code
+	g.rt.expect.handle(method, g.prefix+path, []Handler{func(c *Context) {
+		c.code = uint16(f(c))
+	}})
+}
+
+// run hook of route that request goes to, 100 if there is no hook
+func (r *HTTPRouter) CheckExpect(c *Context) int {
+	s := c.Session
+	rt := r.pick(s).expect
+	if rt == nil {
+		return 100
+	}
+	// request is parsed again when body comes, so path is normalized in free tail of buffer;
+	// raw path could miss hook of route it is served by, so no room means no decision
+	p := s.Req.Path.AsBuf(s)
+	if free := len(s.Buf) - int(s.Offset); len(p) > free {
+		return 431
+	}
+	st := uint16(s.Offset)
+	copy(s.Buf[st:], p)
+	s.Req.Path = engine.View{St: st, End: st + uint16(len(p))}
+	if !r.normalize(s) {
+		return 400
+	}
+	h := rt.lookup(c.Session)
+	if h == nil {
+		return 100
+	}
+	c.code = 100
+	h[0](c)
+	return int(c.code)
+}
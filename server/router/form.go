@@ -0,0 +1,367 @@
+// request forms: application/x-www-form-urlencoded and multipart/form-data (RFC 7578),
+// values are views into body in session buffer, so they live until handler returns
+package router
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"os"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+// body errors carry status, so SendError and Bind callers answer them as is
+var (
+	ErrNotMultipart = errors.New("form: request is not multipart/form-data")
+	ErrBadMultipart = &protocol.StatusError{Code: 400, Msg: "form: malformed multipart body"}
+	ErrTooManyParts = &protocol.StatusError{Code: 413, Msg: "form: too many parts"}
+	ErrMissingFile  = errors.New("form: no such file")
+)
+
+const (
+	DefaultMaxMemory = 32 << 10 // file bytes kept in memory when form is parsed implicitly (FormValue, FormFile)
+	maxParts         = 128
+	maxPartHeaders   = 8
+)
+
+var (
+	mtURLEncoded = []byte("application/x-www-form-urlencoded")
+	mtMultipart  = []byte("multipart/form-data")
+	pBoundary    = []byte("boundary")
+	pName        = []byte("name")
+	pFilename    = []byte("filename")
+	hDisposition = []byte("Content-Disposition")
+	hPartType    = []byte("Content-Type")
+	dashes       = []byte("--")
+)
+
+// one part of multipart form
+type FormPart struct {
+	Name     []byte // form field name
+	Filename []byte // empty for plain fields
+	Size     int
+
+	header []engine.Header // part headers (Content-Disposition, Content-Type...)
+	data   []byte          // part body, nil if it was spilled to disk
+	file   string          // temp file with part body
+}
+
+// parsed multipart form, kept in context and reused between requests
+type Form struct {
+	Parts []FormPart
+
+	hdrs  []engine.Header
+	files []string // temp files, removed when handler returns
+}
+
+// part header by key (case-insensitive)
+func (p *FormPart) Header(key []byte) []byte {
+	for _, h := range p.header {
+		if bytes.EqualFold(h.Key, key) {
+			return h.Val
+		}
+	}
+	return nil
+}
+
+// Content-Type of part
+func (p *FormPart) ContentType() []byte { return p.Header(hPartType) }
+
+// value of plain field (or in-memory file), nil if part is on disk
+func (p *FormPart) Value() []byte { return p.data }
+
+// reader over part body: view of session buffer or temp file when part was bigger than memory limit
+func (p *FormPart) Open() (io.ReadSeekCloser, error) {
+	if p.file != "" {
+		return os.Open(p.file)
+	}
+	return nopCloser{bytes.NewReader(p.data)}, nil
+}
+
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+// media type of request Content-Type is mt (parameters after ';' are ignored)
+func (c *Context) isMedia(mt []byte) bool {
+	ct := c.ContentType()
+	if i := bytes.IndexByte(ct, ';'); i != -1 {
+		ct = ct[:i]
+	}
+	return bytes.EqualFold(bytes.TrimSpace(ct), mt)
+}
+
+// iterate raw pairs of urlencoded body (0 alloc), nothing for other content types
+func (c *Context) FormPairs() iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		if !c.isMedia(mtURLEncoded) {
+			return
+		}
+		for pair := range bytes.SplitSeq(c.Body(), []byte{'&'}) {
+			if len(pair) == 0 {
+				continue
+			}
+			k, v, _ := bytes.Cut(pair, []byte{'='})
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// decoded value of form field (urlencoded or plain multipart field) written to dst,
+// zero-copy view when there is nothing to decode; nil if there is no such field
+func (c *Context) FormValue(key, dst []byte) []byte {
+	if c.isMedia(mtURLEncoded) {
+		for k, v := range c.FormPairs() {
+			if unescapedEqual(k, key) {
+				if bytes.IndexByte(v, '%') == -1 && bytes.IndexByte(v, '+') == -1 {
+					return v
+				}
+				return appendUnescape(dst[:0], v, true)
+			}
+		}
+		return nil
+	}
+
+	f, err := c.implicitForm()
+	if err != nil {
+		return nil
+	}
+	for i := range f.Parts {
+		p := &f.Parts[i]
+		if len(p.Filename) == 0 && bytes.Equal(p.Name, key) {
+			return p.data
+		}
+	}
+	return nil
+}
+
+// first file part of field
+func (c *Context) FormFile(name []byte) (*FormPart, error) {
+	f, err := c.implicitForm()
+	if err != nil {
+		return nil, err
+	}
+	for i := range f.Parts {
+		p := &f.Parts[i]
+		if len(p.Filename) != 0 && bytes.Equal(p.Name, name) {
+			return p, nil
+		}
+	}
+	return nil, ErrMissingFile
+}
+
+// parse multipart body once per request: file parts over maxMemory bytes in total
+// are written to temp files (removed when handler returns), rest stays in session buffer;
+// form parsed implicitly before (FormValue, FormFile, Bind) is parsed again with maxMemory,
+// so parts got from it earlier must not be used after this call
+func (c *Context) MultipartForm(maxMemory int) (*Form, error) {
+	if c.formParsed && !c.formImplicit {
+		return &c.form, c.formErr
+	}
+	return c.parseForm(maxMemory, false)
+}
+
+// form for FormValue, FormFile and Bind: parsed with DefaultMaxMemory unless handler did it already
+func (c *Context) implicitForm() (*Form, error) {
+	if c.formParsed {
+		return &c.form, c.formErr
+	}
+	return c.parseForm(DefaultMaxMemory, true)
+}
+
+func (c *Context) parseForm(maxMemory int, implicit bool) (*Form, error) {
+	if c.formParsed {
+		c.form.reset()
+	}
+	c.formParsed, c.formImplicit = true, implicit
+	c.formErr = c.parseMultipart(maxMemory)
+	return &c.form, c.formErr
+}
+
+func (c *Context) parseMultipart(maxMemory int) error {
+	ct := c.ContentType()
+	mt, params, _ := bytes.Cut(ct, []byte{';'})
+	if !bytes.EqualFold(bytes.TrimSpace(mt), mtMultipart) {
+		return ErrNotMultipart
+	}
+	boundary := param(params, pBoundary)
+	if len(boundary) == 0 || len(boundary) > 70 {
+		return ErrBadMultipart
+	}
+
+	// delimiter is CRLF "--" boundary, first one may be at body start
+	var db [76]byte
+	delim := append(append(append(db[:0], '\r', '\n'), dashes...), boundary...)
+
+	f := &c.form
+	body := c.Body()
+	i := 0
+	if !bytes.HasPrefix(body, delim[2:]) {
+		i = bytes.Index(body, delim)
+		if i == -1 {
+			return ErrBadMultipart
+		}
+		i += 2
+	}
+	i += len(delim) - 2
+
+	for {
+		// "--" after delimiter closes body, otherwise part headers follow CRLF
+		rest := body[i:]
+		if bytes.HasPrefix(rest, dashes) {
+			return nil
+		}
+		rest = bytes.TrimLeft(rest, " \t")
+		if !bytes.HasPrefix(rest, crlf) {
+			return ErrBadMultipart
+		}
+		rest = rest[2:]
+		if len(f.Parts) == maxParts {
+			return ErrTooManyParts
+		}
+
+		var p FormPart
+		hst := len(f.hdrs)
+		for {
+			lf := bytes.Index(rest, crlf)
+			if lf == -1 {
+				return ErrBadMultipart
+			}
+			line := rest[:lf]
+			rest = rest[lf+2:]
+			if lf == 0 {
+				break
+			}
+			k, v, ok := bytes.Cut(line, []byte{':'})
+			if !ok || len(f.hdrs)-hst == maxPartHeaders {
+				return ErrBadMultipart
+			}
+			f.hdrs = append(f.hdrs, engine.Header{Key: k, Val: bytes.TrimSpace(v)})
+		}
+		p.header = f.hdrs[hst:len(f.hdrs):len(f.hdrs)]
+
+		disp := p.Header(hDisposition)
+		dt, dparams, _ := bytes.Cut(disp, []byte{';'})
+		if !bytes.EqualFold(bytes.TrimSpace(dt), []byte("form-data")) {
+			return ErrBadMultipart
+		}
+		p.Name = param(dparams, pName)
+		p.Filename = param(dparams, pFilename)
+
+		end := bytes.Index(rest, delim)
+		if end == -1 {
+			return ErrBadMultipart
+		}
+		p.data = rest[:end]
+		p.Size = end
+
+		// files over memory budget go to disk
+		if len(p.Filename) != 0 {
+			if p.Size > maxMemory {
+				if err := f.spill(&p); err != nil {
+					return &protocol.StatusError{Code: 500, Msg: "form: " + err.Error()}
+				}
+			} else {
+				maxMemory -= p.Size
+			}
+		}
+		f.Parts = append(f.Parts, p)
+		i = len(body) - len(rest) + end + len(delim)
+	}
+}
+
+// write part body to temp file
+func (f *Form) spill(p *FormPart) error {
+	tmp, err := os.CreateTemp("", "goserver-form-*")
+	if err != nil {
+		return err
+	}
+	f.files = append(f.files, tmp.Name())
+	_, err = tmp.Write(p.data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	p.file, p.data = tmp.Name(), nil
+	return nil
+}
+
+// drop parsed parts and temp files
+func (f *Form) reset() {
+	for _, name := range f.files {
+		os.Remove(name)
+	}
+	f.files = f.files[:0]
+	f.Parts = f.Parts[:0]
+	f.hdrs = f.hdrs[:0]
+}
+
+// value of header parameter (; name="value" or ; name=token), quoted-string may hold ';' and
+// \-escapes (RFC 9110 5.6.4); view of params unless value has escapes, then it is copied
+func param(params, key []byte) []byte {
+	for len(params) > 0 {
+		params = bytes.TrimLeft(params, " \t;")
+		eq := bytes.IndexAny(params, "=;")
+		if eq == -1 {
+			return nil
+		}
+		if params[eq] == ';' { // parameter without value
+			params = params[eq:]
+			continue
+		}
+		k := bytes.TrimSpace(params[:eq])
+		params = bytes.TrimLeft(params[eq+1:], " \t")
+
+		var v []byte
+		if len(params) > 0 && params[0] == '"' {
+			var n int
+			v, n = quoted(params)
+			params = params[n:]
+		} else {
+			v, params, _ = bytes.Cut(params, []byte{';'})
+			v = bytes.TrimSpace(v)
+		}
+		if bytes.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// quoted-string at start of b without quotes and escapes, and number of bytes it takes;
+// unterminated string runs to end of b
+func quoted(b []byte) ([]byte, int) {
+	escaped := false
+	end, n := len(b), len(b)
+	for i := 1; i < len(b); i++ {
+		if b[i] == '\\' {
+			escaped = true
+			i++
+		} else if b[i] == '"' {
+			end, n = i, i+1
+			break
+		}
+	}
+	if escaped {
+		return unquote(b[1:end]), n
+	}
+	return b[1:end], n
+}
+
+func unquote(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) {
+			i++
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
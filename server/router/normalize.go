@@ -0,0 +1,139 @@
+// path normalization before routing (RFC 3986 6.2.2): /api/%75ser/./1 and //api/user/1 go to /api/user/1
+package router
+
+import (
+	"bytes"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+// what to do with encoded slash (%2F) in path
+type SlashPolicy uint8
+
+const (
+	SlashKeep   SlashPolicy = iota // stays encoded, it is part of segment (param value has it decoded)
+	SlashDecode                    // decoded, works as segment separator
+	SlashReject                    // request is answered 400
+)
+
+var (
+	dslash = []byte("//")
+	sdot   = []byte("/.")
+)
+
+// request with malformed path, answered before any route handlers
+var badPath = []Handler{func(c *Context) {
+	c.sendresp(400, textPlain[:], protocol.StatusText(400)[4:])
+}}
+
+// normalize request path in place, false for malformed path (bad escape or rejected %2F)
+func (r *HTTPRouter) normalize(s *engine.Session) bool {
+	p := s.Req.Path.AsBuf(s)
+	// asterisk-form (OPTIONS *) or already clean path
+	if len(p) == 0 || p[0] != '/' {
+		return true
+	}
+	if bytes.IndexByte(p, '%') == -1 && !bytes.Contains(p, dslash) && !bytes.Contains(p, sdot) {
+		return true
+	}
+
+	n, ok := normalizePath(p, r.EncodedSlash)
+	s.Req.Path.End = s.Req.Path.St + uint16(n)
+	return ok
+}
+
+// normalize path that starts with slash, result is written over p (it never grows), returns its length:
+// escaped unreserved chars are decoded, other escapes get uppercase hex,
+// dot segments are removed (.. never goes above root) and repeated slashes collapsed
+func normalizePath(p []byte, slash SlashPolicy) (int, bool) {
+	// decode escapes, dots of %2E become dot segments
+	n := 0
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c != '%' {
+			p[n] = c
+			n++
+			continue
+		}
+		if i+2 >= len(p) || !protocol.IsHex(p[i+1]) || !protocol.IsHex(p[i+2]) {
+			return n, false
+		}
+		v := protocol.Unhex(p[i+1])<<4 | protocol.Unhex(p[i+2])
+		switch {
+		case isUnreserved(v), v == '/' && slash == SlashDecode:
+			p[n] = v
+			n++
+		case v == '/' && slash == SlashReject:
+			return n, false
+		default:
+			p[n], p[n+1], p[n+2] = '%', upperHex(p[i+1]), upperHex(p[i+2])
+			n += 3
+		}
+		i += 2
+	}
+
+	// segments: output never outruns input, each written segment had a slash before it
+	out, trail := 0, false
+	for i := 0; i < n; {
+		for i < n && p[i] == '/' {
+			i++
+		}
+		if i == n {
+			trail = true
+			break
+		}
+		e := i + bytes.IndexByte(p[i:n], '/')
+		if e < i {
+			e = n
+		}
+		seg := p[i:e]
+		switch {
+		case len(seg) == 1 && seg[0] == '.':
+			trail = true
+		case len(seg) == 2 && seg[0] == '.' && seg[1] == '.':
+			out = max(bytes.LastIndexByte(p[:out], '/'), 0)
+			trail = true
+		default:
+			p[out] = '/'
+			out += 1 + copy(p[out+1:], seg)
+			trail = false
+		}
+		i = e
+	}
+	if out == 0 || trail {
+		p[out] = '/'
+		out++
+	}
+	return out, true
+}
+
+// unreserved chars of RFC 3986: ALPHA DIGIT - . _ ~
+func isUnreserved(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func upperHex(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// append src with %XX escapes decoded (and '+' as space if plus is set) to dst,
+// malformed escapes are copied as is
+func appendUnescape(dst, src []byte, plus bool) []byte {
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '%' && i+2 < len(src) && protocol.IsHex(src[i+1]) && protocol.IsHex(src[i+2]):
+			c = protocol.Unhex(src[i+1])<<4 | protocol.Unhex(src[i+2])
+			i += 2
+		case c == '+' && plus:
+			c = ' '
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
@@ -0,0 +1,193 @@
+// query string (?a=1&b=2): raw views into session buffer, decoding only into caller buffers
+package router
+
+import (
+	"bytes"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+// parsed query pair, views into session buffer
+type queryPair struct {
+	key, val engine.View
+}
+
+// cached parse keeps up to this many pairs, longer queries are scanned on every lookup
+const maxQueryPairs = 16
+
+// malformed typed query value
+type QueryError struct {
+	Key string
+	Err error
+}
+
+func (e *QueryError) Error() string { return "query " + e.Key + ": " + e.Err.Error() }
+func (e *QueryError) Unwrap() error { return e.Err }
+
+// iterate raw key/value pairs in order (0 alloc), value of "?flag" is empty
+func (c *Context) QueryPairs() iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		if c.parseQuery() {
+			for _, p := range c.qpairs[:c.qn] {
+				if !yield(p.key.AsBuf(c.Session), p.val.AsBuf(c.Session)) {
+					return
+				}
+			}
+			return
+		}
+		for pair := range bytes.SplitSeq(c.Query(), []byte{'&'}) {
+			if len(pair) == 0 {
+				continue
+			}
+			k, v, _ := bytes.Cut(pair, []byte{'='})
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// split query once per request, false if it doesn't fit in cache
+func (c *Context) parseQuery() bool {
+	if c.qparsed {
+		return c.qn <= maxQueryPairs
+	}
+	c.qparsed = true
+
+	q := c.Session.Req.RawQuery
+	buf := c.Session.Buf
+	st, end := int(q.St), int(q.End)
+	for i := st; i <= end; i++ {
+		if i < end && buf[i] != '&' {
+			continue
+		}
+		if i > st {
+			if int(c.qn) == maxQueryPairs {
+				c.qn++ // overflow mark
+				return false
+			}
+			k, v := engine.View{St: uint16(st), End: uint16(i)}, engine.View{St: uint16(i), End: uint16(i)}
+			if eq := bytes.IndexByte(buf[st:i], '='); eq != -1 {
+				k.End = uint16(st + eq)
+				v.St = k.End + 1
+			}
+			c.qpairs[c.qn] = queryPair{key: k, val: v}
+			c.qn++
+		}
+		st = i + 1
+	}
+	return true
+}
+
+// raw value of first pair with key (key is compared decoded, so a%5B%5D matches "a[]")
+func (c *Context) queryLookup(key []byte) ([]byte, bool) {
+	for k, v := range c.QueryPairs() {
+		if unescapedEqual(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// decoded value ('+' is space, %XX escapes) of first pair with key, written to dst;
+// zero-copy view when there is nothing to decode
+func (c *Context) QueryValue(key, dst []byte) []byte {
+	v, _ := c.queryDecoded(key, dst)
+	return v
+}
+
+func (c *Context) queryDecoded(key, dst []byte) ([]byte, bool) {
+	v, ok := c.queryLookup(key)
+	if bytes.IndexByte(v, '%') == -1 && bytes.IndexByte(v, '+') == -1 {
+		return v, ok
+	}
+	return appendUnescape(dst[:0], v, true), ok
+}
+
+// query has key (with or without value)
+func (c *Context) QueryHas(key []byte) bool {
+	_, ok := c.queryLookup(key)
+	return ok
+}
+
+// raw values of all pairs with key appended to buf (?id=1&id=2)
+func (c *Context) QueryAll(key []byte, buf [][]byte) [][]byte {
+	for k, v := range c.QueryPairs() {
+		if unescapedEqual(k, key) {
+			buf = append(buf, v)
+		}
+	}
+	return buf
+}
+
+// integer value, def if key is missing or empty
+func (c *Context) QueryInt(key []byte, def int) (int, error) {
+	var buf [64]byte
+	v := c.QueryValue(key, buf[:])
+	if len(v) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(B2String(v))
+	if err != nil {
+		return def, &QueryError{Key: string(key), Err: err}
+	}
+	return n, nil
+}
+
+// bool value (1, t, true, 0, f, false...), key without value ("?debug") is true
+func (c *Context) QueryBool(key []byte, def bool) (bool, error) {
+	var buf [64]byte
+	v, ok := c.queryDecoded(key, buf[:])
+	if !ok {
+		return def, nil
+	}
+	if len(v) == 0 {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(B2String(v))
+	if err != nil {
+		return def, &QueryError{Key: string(key), Err: err}
+	}
+	return b, nil
+}
+
+// duration value in time.ParseDuration format (300ms, 1h30m), def if key is missing or empty
+func (c *Context) QueryDuration(key []byte, def time.Duration) (time.Duration, error) {
+	var buf [64]byte
+	v := c.QueryValue(key, buf[:])
+	if len(v) == 0 {
+		return def, nil
+	}
+	d, err := time.ParseDuration(B2String(v))
+	if err != nil {
+		return def, &QueryError{Key: string(key), Err: err}
+	}
+	return d, nil
+}
+
+// raw query component equals key after decoding, without decoding to buffer
+func unescapedEqual(raw, key []byte) bool {
+	if bytes.IndexByte(raw, '%') == -1 && bytes.IndexByte(raw, '+') == -1 {
+		return bytes.Equal(raw, key)
+	}
+	j := 0
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '%' && i+2 < len(raw) && protocol.IsHex(raw[i+1]) && protocol.IsHex(raw[i+2]):
+			c = protocol.Unhex(raw[i+1])<<4 | protocol.Unhex(raw[i+2])
+			i += 2
+		case c == '+':
+			c = ' '
+		}
+		if j >= len(key) || key[j] != c {
+			return false
+		}
+		j++
+	}
+	return j == len(key)
+}
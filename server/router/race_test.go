@@ -0,0 +1,6 @@
+//go:build race
+
+package router
+
+// race detector instruments code and allocates, alloc counts are not checked under it
+const raceEnabled = true
@@ -19,10 +19,19 @@ const (
 	mcnt // counts of methods
 )
 
-// http router: store only array of tree root ptrs
+// http router: default routes and routes of virtual hosts
 type HTTPRouter struct {
 	RouteGroup // basically def router is RouteGroup w empty prefix ("/")
 
+	routes        // routes for requests that don't match any virtual host
+	hosts  []host // virtual hosts, see vhost.go
+
+	// %2F in path, see normalize.go (kept encoded by default)
+	EncodedSlash SlashPolicy
+}
+
+// route trees of one host: store only array of tree root ptrs
+type routes struct {
 	trees [mcnt]*node // static trees for common methods for constant search
 
 	// dynamic trees means trees for non-common methods,
@@ -30,6 +39,8 @@ type HTTPRouter struct {
 	// trash realisation using 2 slices :(( should use map
 	dynTrees []*node
 	dynNames []dmentry
+
+	expect *routes // Expect: 100-continue hooks, see expect.go
 }
 
 // dynamic route entry for link id and name
@@ -42,18 +53,23 @@ type dmentry struct {
 // so we can store same paths to GET and POST for example
 func NewHTTPRouter() *HTTPRouter {
 	r := &HTTPRouter{}
-	for i := range mcnt {
-		r.trees[i] = &node{ch: make([]node, 0)}
-	}
+	r.routes.init()
 
 	r.RouteGroup = RouteGroup{
 		prefix:      "",
 		router:      r,
+		rt:          &r.routes,
 		middlewares: []Handler{Recovery},
 	}
 	return r
 }
 
+func (rt *routes) init() {
+	for i := range mcnt {
+		rt.trees[i] = &node{ch: make([]node, 0)}
+	}
+}
+
 // fast parsing method (and unprotected)
 func parseMethod(m []byte) int {
 	if len(m) == 0 {
@@ -81,8 +97,15 @@ func parseMethod(m []byte) int {
 
 // serve: find a handler to path
 func (r *HTTPRouter) Serve(s *engine.Session) []Handler {
-	mi := parseMethod(s.Req.Method.AsBuf(s))
+	rt := r.pick(s)
+	if !r.normalize(s) {
+		return badPath
+	}
+	return rt.lookup(s)
+}
 
+// cut query from path and pick routes of request host
+func (r *HTTPRouter) pick(s *engine.Session) *routes {
 	pb := s.Req.Path.AsBuf(s)
 	if idx := bytes.IndexByte(pb, '?'); idx != -1 {
 		absi := s.Req.Path.St + uint16(idx)
@@ -94,54 +117,70 @@ func (r *HTTPRouter) Serve(s *engine.Session) []Handler {
 		s.Req.Path.End = absi
 	}
 
+	if len(r.hosts) > 0 || isAbsoluteForm(s) {
+		return r.selectHost(s)
+	}
+	return &r.routes
+}
+
+// find handlers in method trees
+func (rt *routes) lookup(s *engine.Session) []Handler {
+	mi := parseMethod(s.Req.Method.AsBuf(s))
+
 	// fast search on common REST methods
 	if mi != mUnknown {
-		return r.trees[mi].match(s)
+		return rt.trees[mi].match(s)
 	}
 
 	// fallback search in dynamic trees
-	for _, entry := range r.dynNames {
+	for _, entry := range rt.dynNames {
 		if bytes.Equal(entry.name, s.Req.Method.AsBuf(s)) {
-			return r.dynTrees[entry.id].match(s)
+			return rt.dynTrees[entry.id].match(s)
 		}
 	}
 
 	return nil
 }
 
-// common func to link file to path ;
-// note: there is 2 allocs when we call []byte(string) but since it's one time it doesnt affect runtime performance
+// common func to link file to path (default host)
 func (r *HTTPRouter) Handle(method, path string, h []Handler) {
+	r.routes.handle(method, path, h)
+}
+
+// note: there is 2 allocs when we call []byte(string) but since it's one time it doesnt affect runtime performance
+func (rt *routes) handle(method, path string, h []Handler) {
 	mb := []byte(method)
 	mi := parseMethod(mb)
 
 	// if method in static -> insert and exit
 	if mi != mUnknown {
-		r.trees[mi].insert([]byte(path), h)
+		rt.trees[mi].insert([]byte(path), h)
 		return
 	}
 
 	// check if tree for method is exist
-	for _, entry := range r.dynNames {
+	for _, entry := range rt.dynNames {
 		if bytes.Equal(entry.name, mb) {
-			r.dynTrees[entry.id].insert([]byte(path), h)
+			rt.dynTrees[entry.id].insert([]byte(path), h)
 			return
 		}
 	}
 
 	// register new dynamic route
-	nid := len(r.dynTrees)
-	r.dynNames = append(r.dynNames, dmentry{name: mb, id: nid})
+	nid := len(rt.dynTrees)
+	rt.dynNames = append(rt.dynNames, dmentry{name: mb, id: nid})
 	nn := &node{ch: make([]node, 0)}
-	r.dynTrees = append(r.dynTrees, nn)
+	rt.dynTrees = append(rt.dynTrees, nn)
 	nn.insert([]byte(path), h)
 }
 
-// Group for routes with general middlewares and prefix
+// Group for routes with general middlewares and prefix,
+// rt is route trees of group host (default routes if group is not bound to host)
 type RouteGroup struct {
 	prefix      string
 	middlewares []Handler
 	router      *HTTPRouter
+	rt          *routes
 }
 
 func NewGroup(prefix string, router *HTTPRouter) *RouteGroup {
@@ -149,6 +188,7 @@ func NewGroup(prefix string, router *HTTPRouter) *RouteGroup {
 		prefix:      prefix,
 		middlewares: []Handler{Recovery},
 		router:      router,
+		rt:          &router.routes,
 	}
 }
 
@@ -158,6 +198,18 @@ func (g *RouteGroup) Group(prefix string) *RouteGroup {
 		prefix:      g.prefix + prefix,
 		middlewares: append([]Handler{}, g.middlewares...),
 		router:      g.router,
+		rt:          g.rt,
+	}
+}
+
+// same group (prefix and middlewares) bound to virtual host,
+// pattern is exact host (api.example.com) or wildcard (*.example.com)
+func (g *RouteGroup) Host(pattern string) *RouteGroup {
+	return &RouteGroup{
+		prefix:      g.prefix,
+		middlewares: append([]Handler{}, g.middlewares...),
+		router:      g.router,
+		rt:          g.router.hostRoutes(pattern),
 	}
 }
 
@@ -175,7 +227,7 @@ func (g *RouteGroup) Handle(method, path string, h Handler) {
 
 	ch[len(g.middlewares)] = h
 
-	g.router.Handle(method, fp, ch)
+	g.rt.handle(method, fp, ch)
 }
 
 // a bit of syntactic sugar =))
@@ -1,8 +1,13 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/s00inx/goserver/server/engine"
 	"github.com/s00inx/goserver/server/protocol"
@@ -59,6 +64,151 @@ func TestRouter_HandleAndServe_WithView(t *testing.T) {
 	}
 }
 
+func TestRouter_VirtualHosts(t *testing.T) {
+	var got string
+	mark := func(name string) Handler {
+		return func(c *Context) { got = name }
+	}
+
+	r := NewHTTPRouter()
+	r.Get("/", mark("default"))
+	r.Host("api.example.com").Get("/", mark("api"))
+	r.Host("*.example.com").Get("/", mark("wildcard"))
+	r.Host("*.eu.example.com").Group("/v1").Get("/:id", mark("eu"))
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"Exact Host", "GET / HTTP/1.1\r\nHost: api.example.com\r\n\r\n", "api"},
+		{"Host Case And Port", "GET / HTTP/1.1\r\nhost: API.Example.com:8080\r\n\r\n", "api"},
+		{"Wildcard", "GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n", "wildcard"},
+		{"Longest Wildcard", "GET /v1/7 HTTP/1.1\r\nHost: fr.eu.example.com\r\n\r\n", "eu"},
+		{"Apex Is Not Wildcard", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "default"},
+		{"Unknown Host", "GET / HTTP/1.1\r\nHost: other.org\r\n\r\n", "default"},
+		{"No Host", "GET / HTTP/1.1\r\n\r\n", "default"},
+		{"Absolute Form", "GET http://api.example.com/?x=1 HTTP/1.1\r\nHost: other.org\r\n\r\n", "api"},
+	}
+
+	p := &protocol.HTTPParser{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			s := &engine.Session{Buf: make([]byte, 1024)}
+			s.Offset = uint32(copy(s.Buf, tt.raw))
+
+			p.Parse(s, func(s *engine.Session, buf []byte) {
+				h := r.Serve(s)
+				if h == nil {
+					t.Fatal("route not found")
+				}
+				h[len(h)-1](&Context{Session: s})
+			})
+			if got != tt.want {
+				t.Errorf("expected %q routes, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		slash SlashPolicy
+		ok    bool
+	}{
+		{"/api/user/1", "/api/user/1", SlashKeep, true},
+		{"/api/%75ser/1", "/api/user/1", SlashKeep, true},
+		{"//api///user/1", "/api/user/1", SlashKeep, true},
+		{"/api/./user/1", "/api/user/1", SlashKeep, true},
+		{"/api/x/../user/1", "/api/user/1", SlashKeep, true},
+		{"/api/%2e%2E/user", "/user", SlashKeep, true},
+		{"/../../etc", "/etc", SlashKeep, true},
+		{"/a/b/.", "/a/b/", SlashKeep, true},
+		{"/a/b/..", "/a/", SlashKeep, true},
+		{"/a/", "/a/", SlashKeep, true},
+		{"/", "/", SlashKeep, true},
+		{"/..", "/", SlashKeep, true},
+		{"/a%20b/c%3f", "/a%20b/c%3F", SlashKeep, true},
+		{"/a%2fb", "/a%2Fb", SlashKeep, true},
+		{"/a%2fb", "/a/b", SlashDecode, true},
+		{"/a%2F%2Fb", "/a/b", SlashDecode, true},
+		{"/a%2fb", "", SlashReject, false},
+		{"/a%zz", "", SlashKeep, false},
+		{"/a%2", "", SlashKeep, false},
+	}
+
+	for _, tt := range tests {
+		p := []byte(tt.in)
+		n, ok := normalizePath(p, tt.slash)
+		if ok != tt.ok || ok && string(p[:n]) != tt.want {
+			t.Errorf("%q: expected %q %v, got %q %v", tt.in, tt.want, tt.ok, p[:n], ok)
+		}
+	}
+}
+
+func TestRouter_NormalizedRouting(t *testing.T) {
+	r := NewHTTPRouter()
+	var id string
+	r.Get("/api/user/:id", func(c *Context) {
+		var buf [64]byte
+		id = string(c.ParamDecoded([]byte("id"), buf[:]))
+	})
+
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	for _, path := range []string{"/api/%75ser/1", "//api/user/1", "/api/./user/1?x=1", "/api/v2/../user/1"} {
+		setSessionView(s, "GET", path)
+		h := r.Serve(s)
+		if h == nil {
+			t.Fatalf("%s: route not found", path)
+		}
+		h[len(h)-1](&Context{Session: s})
+		if id != "1" || string(s.Req.Path.AsBuf(s)) != "/api/user/1" {
+			t.Errorf("%s: got id %q, path %q", path, id, s.Req.Path.AsBuf(s))
+		}
+	}
+
+	// encoded slash stays in param and is decoded by ParamDecoded
+	setSessionView(s, "GET", "/api/user/john%2Fdoe%20jr")
+	h := r.Serve(s)
+	h[len(h)-1](&Context{Session: s})
+	if id != "john/doe jr" {
+		t.Errorf("expected decoded param, got %q", id)
+	}
+
+	r.EncodedSlash = SlashReject
+	setSessionView(s, "GET", "/api/user/a%2Fb")
+	if h := r.Serve(s); &h[0] != &badPath[0] {
+		t.Error("expected bad path handlers")
+	}
+}
+
+func TestRouter_CheckExpect(t *testing.T) {
+	r := NewHTTPRouter()
+	r.Expect("POST", "/secret", func(c *Context) int { return 401 })
+
+	s := &engine.Session{Buf: make([]byte, 64)}
+	cases := []struct {
+		path string
+		free int
+		want int
+	}{
+		{"/%73ecret", 32, 401},
+		{"/open", 32, 100},
+		// no room to normalize in: raw path must not be looked up
+		{"/%73ecret", 4, 431},
+	}
+	for _, tc := range cases {
+		setSessionView(s, "POST", tc.path)
+		s.Offset = uint32(len(s.Buf) - tc.free)
+		if got := r.CheckExpect(&Context{Session: s}); got != tc.want {
+			t.Errorf("%s with %d free bytes: expected %d, got %d", tc.path, tc.free, tc.want, got)
+		}
+	}
+}
+
 func BenchmarkRouter_Serve_View(b *testing.B) {
 	r := NewHTTPRouter()
 	r.Get("/api/v1/resource/item/details", dummyHandler)
@@ -188,3 +338,408 @@ func BenchmarkRouter_Chaos_Fixed(b *testing.B) {
 		}
 	}
 }
+
+func TestContext_Query(t *testing.T) {
+	r := NewHTTPRouter()
+	r.Get("/q", dummyHandler)
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	setSessionView(s, "GET", "/q?name=John+Doe&id=1&id=2&tag%5B%5D=a%26b&debug&n=42&on=false&t=1m30s&bad=x&&")
+	r.Serve(s)
+	c := &Context{}
+	c.Reset(s, nil)
+
+	var buf [64]byte
+	if v := c.QueryValue([]byte("name"), buf[:]); string(v) != "John Doe" {
+		t.Errorf("name: %q", v)
+	}
+	if v := c.QueryValue([]byte("tag[]"), buf[:]); string(v) != "a&b" {
+		t.Errorf("tag[]: %q", v)
+	}
+	if v := c.QueryGet([]byte("name")); string(v) != "John+Doe" {
+		t.Errorf("raw name: %q", v)
+	}
+	if all := c.QueryAll([]byte("id"), nil); len(all) != 2 || string(all[0]) != "1" || string(all[1]) != "2" {
+		t.Errorf("ids: %q", all)
+	}
+	if !c.QueryHas([]byte("debug")) || c.QueryHas([]byte("missing")) {
+		t.Error("QueryHas")
+	}
+
+	if n, err := c.QueryInt([]byte("n"), 0); n != 42 || err != nil {
+		t.Errorf("n: %d %v", n, err)
+	}
+	if n, err := c.QueryInt([]byte("missing"), 7); n != 7 || err != nil {
+		t.Errorf("missing: %d %v", n, err)
+	}
+	if n, err := c.QueryInt([]byte("bad"), 7); n != 7 || err == nil {
+		t.Errorf("bad: %d %v", n, err)
+	}
+	if b, err := c.QueryBool([]byte("debug"), false); !b || err != nil {
+		t.Errorf("debug: %v %v", b, err)
+	}
+	if b, err := c.QueryBool([]byte("on"), true); b || err != nil {
+		t.Errorf("on: %v %v", b, err)
+	}
+	if d, err := c.QueryDuration([]byte("t"), 0); d != 90*time.Second || err != nil {
+		t.Errorf("t: %v %v", d, err)
+	}
+
+	pairs := 0
+	for range c.QueryPairs() {
+		pairs++
+	}
+	if pairs != 9 {
+		t.Errorf("expected 9 pairs, got %d", pairs)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.QueryValue([]byte("tag[]"), buf[:])
+		c.QueryInt([]byte("n"), 0)
+		c.QueryAll([]byte("id"), nil)
+	})
+	if allocs > 1 && !raceEnabled { // QueryAll with nil buf
+		t.Errorf("expected no allocs, got %v", allocs)
+	}
+}
+
+func TestContext_QueryLong(t *testing.T) {
+	q := "/q?"
+	for i := range 40 {
+		q += fmt.Sprintf("k%d=%d&", i, i)
+	}
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	setSessionView(s, "GET", q)
+	NewHTTPRouter().Serve(s)
+	c := &Context{}
+	c.Reset(s, nil)
+
+	// doesn't fit in cache, scanned on every lookup
+	for _, i := range []int{0, 15, 16, 39} {
+		if n, err := c.QueryInt([]byte(fmt.Sprintf("k%d", i)), -1); n != i || err != nil {
+			t.Errorf("k%d: %d %v", i, n, err)
+		}
+	}
+}
+
+func TestContext_HeaderCaseInsensitive(t *testing.T) {
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	s.Offset = uint32(copy(s.Buf, "GET / HTTP/1.1\r\nContent-Type: text/plain\r\nX-Request-Id: 7\r\n\r\n"))
+
+	(&protocol.HTTPParser{}).Parse(s, func(s *engine.Session, buf []byte) {
+		c := &Context{Session: s}
+		for _, k := range []string{"Content-Type", "content-type", "CONTENT-TYPE"} {
+			if string(c.Header([]byte(k))) != "text/plain" {
+				t.Errorf("%s: not found", k)
+			}
+		}
+		if string(c.Header([]byte("x-request-id"))) != "7" || string(c.ContentType()) != "text/plain" {
+			t.Error("lookup failed")
+		}
+		if c.Header([]byte("Host")) != nil {
+			t.Error("unexpected Host")
+		}
+	})
+}
+
+func TestContext_Cookies(t *testing.T) {
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	s.Offset = uint32(copy(s.Buf, "GET / HTTP/1.1\r\ncookie: sid=abc; theme=\"dark\"; empty=\r\nX: 1\r\nCookie: lang=en\r\n\r\n"))
+
+	(&protocol.HTTPParser{}).Parse(s, func(s *engine.Session, buf []byte) {
+		c := &Context{}
+		c.Reset(s, nil)
+
+		var got []string
+		for n, v := range c.Cookies() {
+			got = append(got, string(n)+"="+string(v))
+		}
+		if strings.Join(got, " ") != "sid=abc theme=dark empty= lang=en" {
+			t.Errorf("cookies: %v", got)
+		}
+		if string(c.Cookie([]byte("lang"))) != "en" || c.Cookie([]byte("none")) != nil {
+			t.Error("Cookie lookup failed")
+		}
+		if !strings.HasPrefix(string(c.GetCookies()), "sid=abc") {
+			t.Errorf("GetCookies: %q", c.GetCookies())
+		}
+
+		if err := c.SetCookie(&Cookie{Name: "a", Value: "1", Path: "/", MaxAge: 60, HttpOnly: true, SameSite: SameSiteLax}); err != nil {
+			t.Fatal(err)
+		}
+		c.SetCookie(&Cookie{Name: "b", Value: "2", Domain: "example.com",
+			Expires: time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC), Secure: true, Partitioned: true})
+		if err := c.SetCookie(&Cookie{Name: "bad name", Value: "x"}); err != ErrInvalidCookie {
+			t.Errorf("expected ErrInvalidCookie, got %v", err)
+		}
+		if err := c.SetCookie(&Cookie{Name: "x", Value: "a;b"}); err != ErrInvalidCookie {
+			t.Errorf("expected ErrInvalidCookie, got %v", err)
+		}
+
+		want := []string{
+			"a=1; Path=/; Max-Age=60; HttpOnly; SameSite=Lax",
+			"b=2; Domain=example.com; Expires=Mon, 19 Oct 2026 10:00:00 GMT; Secure; Partitioned",
+		}
+		if c.hC != 2 {
+			t.Fatalf("expected 2 Set-Cookie headers, got %d", c.hC)
+		}
+		for i, w := range want {
+			if h := c.resH[i]; string(h.Key) != "Set-Cookie" || string(h.Val) != w {
+				t.Errorf("%d: got %s: %s", i, h.Key, h.Val)
+			}
+		}
+
+		for range len(c.resH) - 2 {
+			if err := c.SetCookie(&Cookie{Name: "n", Value: "v"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.SetCookie(&Cookie{Name: "n", Value: "v"}); err != ErrTooManyHeaders {
+			t.Errorf("expected ErrTooManyHeaders, got %v", err)
+		}
+	})
+}
+
+func TestFormParam(t *testing.T) {
+	cases := []struct {
+		params, key, want string
+	}{
+		{`; name="f"; filename="a;b.txt"`, "filename", "a;b.txt"},
+		{`; name="f"; filename="a;b.txt"`, "name", "f"},
+		{`; filename="say \"hi\".txt"; name=x`, "filename", `say "hi".txt`},
+		{`; filename="say \"hi\".txt"; name=x`, "name", "x"},
+		{`; Boundary = abc ; charset=utf-8`, "boundary", "abc"},
+		{`; flag; name=v`, "name", "v"},
+		{`; filename="unterminated`, "filename", "unterminated"},
+		{`; name=v`, "missing", ""},
+	}
+	for _, c := range cases {
+		if got := param([]byte(c.params), []byte(c.key)); string(got) != c.want {
+			t.Errorf("param(%q, %s): expected %q, got %q", c.params, c.key, c.want, got)
+		}
+	}
+}
+
+func TestContext_StreamHeaderSlots(t *testing.T) {
+	c := &Context{}
+	c.Reset(&engine.Session{Buf: make([]byte, 1024)}, nil)
+	for range len(c.resH) - 1 {
+		c.SetHeader([]byte("X"), []byte("1"))
+	}
+	// framing headers must not be dropped silently
+	if _, err := c.SSE(); err != ErrTooManyHeaders {
+		t.Errorf("SSE: expected ErrTooManyHeaders, got %v", err)
+	}
+	c.SetHeader([]byte("X"), []byte("1"))
+	if _, err := c.Chunked(200); err != ErrTooManyHeaders {
+		t.Errorf("Chunked: expected ErrTooManyHeaders, got %v", err)
+	}
+	if c.cw.open {
+		t.Error("writer is left open")
+	}
+}
+
+func TestContext_FormErrors(t *testing.T) {
+	multipart := func(parts ...string) string {
+		body := ""
+		for _, p := range parts {
+			body += "--b\r\n" + p + "\r\n"
+		}
+		body += "--b--\r\n"
+		return "POST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: " +
+			strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	field := "Content-Disposition: form-data; name=\"n\"\r\n\r\n7"
+	file := "Content-Disposition: form-data; name=\"f\"; filename=\"f.bin\"\r\n\r\n" + strings.Repeat("x", DefaultMaxMemory+1)
+
+	run := func(raw string, h func(c *Context)) {
+		t.Helper()
+		s := &engine.Session{Buf: make([]byte, 1<<16)}
+		s.Offset = uint32(copy(s.Buf, raw))
+		called := false
+		(&protocol.HTTPParser{}).Parse(s, func(s *engine.Session, buf []byte) {
+			called = true
+			c := &Context{}
+			c.Reset(s, nil)
+			h(c)
+			c.End()
+		})
+		if !called {
+			t.Fatal("request is not parsed")
+		}
+	}
+	type target struct {
+		N int `form:"n"`
+	}
+
+	// implicit parse spills big file, explicit one with bigger limit parses again and keeps it in memory
+	run(multipart(field, file), func(c *Context) {
+		var b [8]byte
+		if string(c.FormValue([]byte("n"), b[:])) != "7" {
+			t.Fatal("FormValue failed")
+		}
+		if p, _ := c.FormFile([]byte("f")); p == nil || p.Value() != nil {
+			t.Fatal("expected file spilled by implicit parse")
+		}
+		if _, err := c.MultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if p, _ := c.FormFile([]byte("f")); p == nil || len(p.Value()) != DefaultMaxMemory+1 {
+			t.Error("explicit limit is not applied after implicit parse")
+		}
+	})
+
+	// broken body is error of Bind, not missing field
+	run(multipart(field, "no headers end"), func(c *Context) {
+		var v target
+		if err := c.Bind(&v); err != ErrBadMultipart || protocol.StatusCode(err) != 400 {
+			t.Errorf("expected ErrBadMultipart, got %v", err)
+		}
+	})
+	parts := make([]string, maxParts+1)
+	for i := range parts {
+		parts[i] = field
+	}
+	run(multipart(parts...), func(c *Context) {
+		var v target
+		if err := c.Bind(&v); protocol.StatusCode(err) != 413 {
+			t.Errorf("expected 413, got %v", err)
+		}
+	})
+}
+
+func TestContext_Bind(t *testing.T) {
+	type Page struct {
+		Limit int `query:"limit"`
+	}
+	type req struct {
+		Page
+		ID      uint64        `path:"id"`
+		Name    string        `json:"name"`
+		Tags    []string      `query:"tag"`
+		Debug   bool          `query:"debug"`
+		Timeout time.Duration `query:"t"`
+		Since   *time.Time    `query:"since"`
+		Token   string        `header:"X-Token"`
+		Skip    string        `query:"-"`
+	}
+
+	r := NewHTTPRouter()
+	r.Post("/users/:id", dummyHandler)
+	body := `{"name":"John"}`
+	s := &engine.Session{Buf: make([]byte, 1024)}
+	s.Offset = uint32(copy(s.Buf, "POST /users/42?limit=10&tag=a&tag=b%20c&debug&t=2s&since=2026-10-19T10:00:00Z HTTP/1.1\r\n"+
+		"x-token: secret\r\nContent-Type: application/json; charset=utf-8\r\nContent-Length: "+fmt.Sprint(len(body))+"\r\n\r\n"+body))
+
+	(&protocol.HTTPParser{}).Parse(s, func(s *engine.Session, buf []byte) {
+		r.Serve(s)
+		c := &Context{}
+		c.Reset(s, nil)
+
+		var v req
+		if err := c.Bind(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.ID != 42 || v.Name != "John" || v.Limit != 10 || strings.Join(v.Tags, ",") != "a,b c" ||
+			!v.Debug || v.Timeout != 2*time.Second || v.Token != "secret" || v.Since == nil || v.Since.Day() != 19 {
+			t.Errorf("bad bind: %+v", v)
+		}
+
+		var bad struct {
+			ID    bool `path:"id"`
+			Limit bool `query:"limit"`
+			Name  int  `json:"name"`
+		}
+		err := c.Bind(&bad)
+		var errs BindErrors
+		if !errors.As(err, &errs) || len(errs) != 3 {
+			t.Fatalf("expected 3 field errors, got %v", err)
+		}
+		if errs[0].Source != "json" || errs[0].Field != "name" || errs[1].Field != "ID" || errs[2].Key != "limit" {
+			t.Errorf("bad field errors: %v", err)
+		}
+
+		if err := c.Bind(v); err != ErrBindTarget {
+			t.Errorf("expected ErrBindTarget, got %v", err)
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			var p Page
+			c.BindQuery(&p)
+		})
+		if allocs > 2 && !raceEnabled { // target and scratch buffers, no metadata rebuild
+			t.Errorf("expected cached metadata, got %v allocs", allocs)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	RegisterValidator("even", func(v reflect.Value, _ string) bool { return v.Int()%2 == 0 })
+
+	type Addr struct {
+		City string `json:"city" validate:"required"`
+	}
+	type user struct {
+		Name  string   `json:"name" validate:"required,min=2,max=8"`
+		Code  string   `query:"code" validate:"len=3"`
+		Role  string   `json:"role" validate:"oneof=admin user"`
+		Email string   `json:"email" validate:"omitempty,email"`
+		ID    string   `path:"id" validate:"uuid"`
+		Slug  string   `validate:"regexp=^[a-z]{1,3}(-[a-z]+)?$"`
+		Age   *int     `json:"age" validate:"required,min=18"`
+		Tags  []string `json:"tags" validate:"max=2"`
+		Num   int      `json:"num" validate:"even"`
+		Addr  Addr     `json:"addr"`
+		Alt   []*Addr  `json:"alt"`
+	}
+
+	age := 20
+	ok := user{Name: "John", Code: "abc", Role: "user", ID: "123e4567-e89b-12d3-a456-426614174000",
+		Slug: "ab-cd", Age: &age, Num: 2, Addr: Addr{City: "Oslo"}, Alt: []*Addr{{City: "Rome"}, nil}}
+	if err := Validate(&ok); err != nil {
+		t.Fatal(err)
+	}
+
+	young := 0
+	bad := user{Name: "J", Code: "ab", Role: "root", Email: "John <j@x.io>", ID: "123e4567e89b12d3a456426614174000",
+		Slug: "abcd", Age: &young, Tags: []string{"a", "b", "c"}, Num: 3, Alt: []*Addr{{}}}
+	err := Validate(bad)
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	var got []string
+	for _, v := range ve.Violations {
+		got = append(got, v.Field+":"+v.Rule)
+	}
+	want := "name:min code:len role:oneof email:email id:uuid Slug:regexp age:min tags:max num:even addr.city:required alt[0].city:required"
+	if strings.Join(got, " ") != want {
+		t.Errorf("violations:\n got %s\nwant %s", strings.Join(got, " "), want)
+	}
+
+	// nil pointer is missing, empty optional value is skipped
+	bad = user{Name: "John", Code: "abc", Role: "admin", ID: ok.ID, Slug: "a", Addr: ok.Addr}
+	if err := Validate(&bad); err == nil || len(err.(*ValidationError).Violations) != 1 || err.(*ValidationError).Violations[0].Field != "age" {
+		t.Errorf("expected only age violation, got %v", err)
+	}
+
+	// bad tags are errors of Validate, nested ones too, and panic when handler is built
+	type badTag struct {
+		X int `validate:"nope"`
+	}
+	type outer struct {
+		In []badTag
+	}
+	for _, v := range []any{&badTag{}, &outer{}} {
+		if err := Validate(v); err == nil || !strings.Contains(err.Error(), `unknown rule "nope"`) {
+			t.Errorf("%T: expected tag error, got %v", v, err)
+		}
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Bound to panic on bad tag")
+			}
+		}()
+		Bound(func(*Context, *outer) {})
+	}()
+}
@@ -0,0 +1,215 @@
+// server-sent events: response is kept open and events are pushed from any goroutine
+package router
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+var (
+	ErrStreamClosed = errors.New("sse: stream closed")
+	ErrNoStreaming  = errors.New("router: connection can't be streamed")
+	ErrBadSSEField  = errors.New("sse: line break in id, event name or comment")
+)
+
+var sseHeaders = []engine.Header{
+	{Key: []byte("Content-Type"), Val: []byte("text/event-stream")},
+	{Key: []byte("Cache-Control"), Val: []byte("no-cache")},
+	{Key: []byte("Connection"), Val: []byte("close")},
+}
+
+// open event stream, writes are safe from any goroutine
+type Stream struct {
+	s    *engine.Session
+	mu   sync.Mutex
+	buf  []byte // pending fields (id, retry) + event being written
+	done chan struct{}
+
+	closed bool
+}
+
+// start event stream: send header block without Content-Length (body lasts until conn is closed),
+// session is not evicted by idle timer and client data is ignored while stream is open;
+// handler may return right after, stream stays alive until Close or client disconnect
+func (c *Context) SSE() (*Stream, error) {
+	s := c.Session
+	if s.Responder != nil {
+		return nil, ErrNoStreaming
+	}
+
+	// without them client would take conn for reusable one
+	if len(c.resH)-int(c.hC) < len(sseHeaders) {
+		return nil, ErrTooManyHeaders
+	}
+	for _, h := range sseHeaders {
+		c.SetHeader(h.Key, h.Val)
+	}
+	hdrs := c.resH[:c.hC]
+
+	engine.WriteV(s, func(dst []byte) int {
+		return protocol.BuildHeader(200, hdrs, -1, dst, s.Date())
+	}, nil)
+	// header must be sent before events, and events are written directly
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+
+	st := &Stream{s: s, done: make(chan struct{})}
+	s.Hijack(discard)
+	s.SetPersistent(true)
+	s.OnClose(st.onClose)
+	return st, nil
+}
+
+// read handler for streaming conns: request data is dropped, client close is noticed by engine
+func discard(s *engine.Session) (bool, error) {
+	s.Offset = 0
+	return true, nil
+}
+
+// closed when client is gone or stream is closed
+func (st *Stream) Done() <-chan struct{} {
+	return st.done
+}
+
+// set id of next event (Last-Event-ID on reconnect), line breaks would start new fields
+// and clients ignore ids with NUL, so both are rejected
+func (st *Stream) ID(id string) error {
+	if !singleLine(id) || strings.IndexByte(id, 0) != -1 {
+		return ErrBadSSEField
+	}
+	st.mu.Lock()
+	st.buf = appendField(st.buf, "id", []byte(id))
+	st.mu.Unlock()
+	return nil
+}
+
+// set client reconnect delay, sent with next event
+func (st *Stream) Retry(d time.Duration) {
+	st.mu.Lock()
+	st.buf = append(st.buf, "retry: "...)
+	st.buf = strconv.AppendInt(st.buf, d.Milliseconds(), 10)
+	st.buf = append(st.buf, '\n')
+	st.mu.Unlock()
+}
+
+// send named event, multi-line data is split into several data fields on CRLF, LF and lone CR
+// (all of them end line for client), name must be one line
+func (st *Stream) Event(name string, data []byte) error {
+	if !singleLine(name) {
+		return ErrBadSSEField
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if name != "" {
+		st.buf = appendField(st.buf, "event", []byte(name))
+	}
+	for {
+		i := bytes.IndexAny(data, "\r\n")
+		if i == -1 {
+			st.buf = appendField(st.buf, "data", data)
+			break
+		}
+		st.buf = appendField(st.buf, "data", data[:i])
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			i++
+		}
+		data = data[i+1:]
+	}
+	st.buf = append(st.buf, '\n')
+	return st.flush()
+}
+
+// send unnamed event (message)
+func (st *Stream) Data(data []byte) error {
+	return st.Event("", data)
+}
+
+// send comment line, ignored by clients (keeps proxies from closing idle conn)
+func (st *Stream) Comment(text string) error {
+	if !singleLine(text) {
+		return ErrBadSSEField
+	}
+	var b [128]byte
+	p := append(b[:0], ':')
+	if text != "" {
+		p = append(p, ' ')
+		p = append(p, text...)
+	}
+	p = append(p, '\n', '\n')
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.write(p)
+}
+
+// send empty comment every interval until stream is done
+func (st *Stream) Heartbeat(interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-st.done:
+				return
+			case <-t.C:
+				if st.Comment("") != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// end stream, conn is closed (body of stream is delimited by close)
+func (st *Stream) Close() {
+	st.mu.Lock()
+	if !st.closed {
+		st.s.Shutdown()
+	}
+	st.mu.Unlock()
+}
+
+// mu must be held
+func (st *Stream) flush() error {
+	err := st.write(st.buf)
+	st.buf = st.buf[:0]
+	return err
+}
+
+// mu must be held
+func (st *Stream) write(p []byte) error {
+	if st.closed {
+		return ErrStreamClosed
+	}
+	_, err := st.s.WriteDirect(nil, p)
+	return err
+}
+
+// engine close hook, session can't be used after it
+func (st *Stream) onClose(*engine.Session) {
+	st.mu.Lock()
+	st.closed = true
+	st.mu.Unlock()
+	close(st.done)
+}
+
+func singleLine(v string) bool {
+	return strings.IndexAny(v, "\r\n") == -1
+}
+
+func appendField(b []byte, name string, val []byte) []byte {
+	b = append(b, name...)
+	b = append(b, ": "...)
+	b = append(b, val...)
+	return append(b, '\n')
+}
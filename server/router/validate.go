@@ -0,0 +1,453 @@
+// declarative validation by `validate:"required,min=1,max=64"` tags, rules of every type are compiled once and cached;
+// regexp takes rest of the tag, so it goes last: `validate:"required,regexp=^[a-z]{2,5}$"`
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/protocol"
+)
+
+// custom rule, v is field value (pointers are dereferenced), param is text after '=' in tag
+type ValidatorFunc func(v reflect.Value, param string) bool
+
+// one failed rule, field is json (or source) name, dotted path for nested structs
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// all failed rules of struct
+type ValidationError struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Field + ": " + v.Message
+	}
+	return "validate: " + strings.Join(msgs, "; ")
+}
+
+var (
+	customMu   sync.RWMutex
+	customFunc = map[string]ValidatorFunc{}
+)
+
+// add rule usable in validate tags, register it before first use of types with it (init is fine),
+// rules are resolved when type metadata is built
+func RegisterValidator(name string, f ValidatorFunc) {
+	customMu.Lock()
+	customFunc[name] = f
+	customMu.Unlock()
+}
+
+type rule struct {
+	name, param string
+	check       func(v reflect.Value) bool
+}
+
+type validField struct {
+	index     []int
+	name      string
+	rules     []rule
+	required  bool
+	omitempty bool
+	nested    bool         // struct, *struct or []struct, checked by its own rules
+	elem      reflect.Type // struct type of nested field
+}
+
+// rules of struct type, err is bad tag of type itself
+type validMetaEntry struct {
+	fields []validField
+	err    error
+}
+
+var (
+	validCache sync.Map // reflect.Type -> *validMetaEntry
+	tagCache   sync.Map // reflect.Type -> error of type and all nested types (nil is stored too)
+)
+
+// check struct (or pointer to it) against its validate tags, *ValidationError lists every failed rule;
+// bad tag (unknown rule, malformed param) is returned as plain error
+func Validate(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	if err := checkTags(rv.Type()); err != nil {
+		return err
+	}
+	var vs []Violation
+	vs = validateStruct(rv, "", vs)
+	if len(vs) > 0 {
+		return &ValidationError{Violations: vs}
+	}
+	return nil
+}
+
+// Bind and Validate, on failure answers 400 (malformed values) or 422 with violations as json
+// and returns false, so handler just returns
+func (c *Context) BindValid(v any) bool {
+	err := c.Bind(v)
+	if err == nil {
+		err = Validate(v)
+	}
+	if err == nil {
+		return true
+	}
+	c.SendError(err)
+	return false
+}
+
+// handler with request bound to T and validated, invalid requests never reach h;
+// bad validate tags of T panic here, when route is registered
+func Bound[T any](h func(c *Context, v *T)) Handler {
+	if t := reflect.TypeFor[T](); t.Kind() == reflect.Struct {
+		if err := checkTags(t); err != nil {
+			panic(err)
+		}
+	}
+	return func(c *Context) {
+		v := new(T)
+		if c.BindValid(v) {
+			h(c, v)
+		}
+	}
+}
+
+var appJSON = [...]engine.Header{{Key: []byte("Content-Type"), Val: []byte("application/json")}}
+
+// answer bind or validation error: 422 with json violations, 400 with json field errors for malformed values,
+// own status for protocol.StatusError (ErrBadMultipart, ErrTooManyParts), 500 for anything else
+func (c *Context) SendError(err error) {
+	var ve *ValidationError
+	var be BindErrors
+	switch {
+	case errors.As(err, &ve):
+		b, _ := json.Marshal(ve)
+		c.sendresp(422, appJSON[:], b)
+	case errors.As(err, &be):
+		b, _ := json.Marshal(be)
+		c.sendresp(400, appJSON[:], b)
+	case protocol.StatusCode(err) != 0:
+		code := protocol.StatusCode(err)
+		c.sendresp(code, textPlain[:], protocol.StatusText(code)[4:])
+	default:
+		c.Send500()
+	}
+}
+
+func validateStruct(rv reflect.Value, prefix string, vs []Violation) []Violation {
+	// tags are checked by Validate, so nested types have no errors here
+	fields, _ := validMeta(rv.Type())
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		name := prefix + f.name
+
+		// pointer is required to be set, not to point to non-zero value
+		ptr := fv.Kind() == reflect.Pointer
+		if ptr {
+			if fv.IsNil() {
+				if f.required {
+					vs = append(vs, violation(name, rule{name: "required"}))
+				}
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if f.omitempty && !ptr && isEmpty(fv) {
+			continue
+		}
+		for _, r := range f.rules {
+			if ptr && r.name == "required" {
+				continue
+			}
+			if !r.check(fv) {
+				vs = append(vs, violation(name, r))
+			}
+		}
+
+		if !f.nested {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			vs = validateStruct(fv, name+".", vs)
+		case reflect.Slice, reflect.Array:
+			for i := range fv.Len() {
+				ev := reflect.Indirect(fv.Index(i))
+				if ev.Kind() == reflect.Struct {
+					vs = validateStruct(ev, name+"["+strconv.Itoa(i)+"].", vs)
+				}
+			}
+		}
+	}
+	return vs
+}
+
+func violation(field string, r rule) Violation {
+	v := Violation{Field: field, Rule: r.name, Param: r.param}
+	switch r.name {
+	case "required":
+		v.Message = "is required"
+	case "min":
+		v.Message = "must be at least " + r.param
+	case "max":
+		v.Message = "must be at most " + r.param
+	case "len":
+		v.Message = "must have length " + r.param
+	case "regexp":
+		v.Message = "must match " + r.param
+	case "oneof":
+		v.Message = "must be one of " + r.param
+	case "email":
+		v.Message = "must be an email address"
+	case "uuid":
+		v.Message = "must be a UUID"
+	default:
+		v.Message = "failed " + r.name
+	}
+	return v
+}
+
+// cached rules of struct type
+func validMeta(t reflect.Type) ([]validField, error) {
+	if m, ok := validCache.Load(t); ok {
+		e := m.(*validMetaEntry)
+		return e.fields, e.err
+	}
+	e := &validMetaEntry{}
+	e.err = collectRules(t, nil, &e.fields)
+	m, _ := validCache.LoadOrStore(t, e)
+	e = m.(*validMetaEntry)
+	return e.fields, e.err
+}
+
+// first bad tag of struct type or of struct types nested in it, cached
+func checkTags(t reflect.Type) error {
+	if err, ok := tagCache.Load(t); ok {
+		err, _ := err.(error)
+		return err
+	}
+	err := walkTags(t, map[reflect.Type]bool{})
+	tagCache.Store(t, err)
+	return err
+}
+
+func walkTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	fields, err := validMeta(t)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.nested {
+			if err := walkTags(f.elem, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func collectRules(t reflect.Type, index []int, fields *[]validField) error {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+
+		tag, tagged := sf.Tag.Lookup("validate")
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && !tagged {
+			if err := collectRules(sf.Type, idx, fields); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+
+		f := validField{index: idx, name: fieldName(sf)}
+		f.elem, f.nested = nestedStruct(sf.Type)
+		for tag != "" {
+			var r string
+			if strings.HasPrefix(tag, "regexp=") {
+				r, tag = tag, ""
+			} else {
+				r, tag, _ = strings.Cut(tag, ",")
+			}
+			name, param, _ := strings.Cut(r, "=")
+			switch name {
+			case "":
+			case "required":
+				f.required = true
+				f.rules = append(f.rules, rule{name: name, check: func(v reflect.Value) bool { return !isEmpty(v) }})
+			case "omitempty":
+				f.omitempty = true
+			default:
+				r, err := compileRule(t, sf, name, param)
+				if err != nil {
+					return err
+				}
+				f.rules = append(f.rules, r)
+			}
+		}
+		if len(f.rules) > 0 || f.nested {
+			*fields = append(*fields, f)
+		}
+	}
+	return nil
+}
+
+// name of field in violations: json name, then bind tag key, then Go name
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range [...]string{"json", "path", "query", "header", "form"} {
+		if v, ok := sf.Tag.Lookup(tag); ok {
+			if n, _, _ := strings.Cut(v, ","); n != "" && n != "-" {
+				return n
+			}
+		}
+	}
+	return sf.Name
+}
+
+// struct type of values to descend into, if type has them
+func nestedStruct(t reflect.Type) (reflect.Type, bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	// time.Time and other text values are leaves
+	return t, t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshaler)
+}
+
+func compileRule(t reflect.Type, sf reflect.StructField, name, param string) (rule, error) {
+	bad := func(why string) (rule, error) {
+		return rule{}, errors.New("validate: " + t.String() + "." + sf.Name + ": " + why)
+	}
+	r := rule{name: name, param: param}
+	switch name {
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return bad("bad " + name + " param " + strconv.Quote(param))
+		}
+		switch name {
+		case "min":
+			r.check = func(v reflect.Value) bool { x, ok := size(v); return ok && x >= n }
+		case "max":
+			r.check = func(v reflect.Value) bool { x, ok := size(v); return ok && x <= n }
+		default:
+			r.check = func(v reflect.Value) bool { x, ok := size(v); return ok && x == n }
+		}
+	case "regexp":
+		re, err := regexp.Compile(param)
+		if err != nil {
+			return bad(err.Error())
+		}
+		r.check = func(v reflect.Value) bool { return v.Kind() == reflect.String && re.MatchString(v.String()) }
+	case "oneof":
+		opts := strings.Fields(param)
+		r.check = func(v reflect.Value) bool {
+			s, ok := scalar(v)
+			for _, o := range opts {
+				if ok && s == o {
+					return true
+				}
+			}
+			return false
+		}
+	case "email":
+		r.check = func(v reflect.Value) bool {
+			if v.Kind() != reflect.String {
+				return false
+			}
+			a, err := mail.ParseAddress(v.String())
+			return err == nil && a.Name == "" && a.Address == v.String()
+		}
+	case "uuid":
+		r.check = func(v reflect.Value) bool { return v.Kind() == reflect.String && isUUID(v.String()) }
+	default:
+		customMu.RLock()
+		fn := customFunc[name]
+		customMu.RUnlock()
+		if fn == nil {
+			return bad("unknown rule " + strconv.Quote(name))
+		}
+		r.check = func(v reflect.Value) bool { return fn(v, param) }
+	}
+	return r, nil
+}
+
+// number value or length (runes of string, elements of slice/map)
+func size(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+// string form of string, int and uint values for oneof
+func scalar(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	}
+	return "", false
+}
+
+// zero value, empty slice or map
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// 8-4-4-4-12 hex digits
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := range len(s) {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !protocol.IsHex(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+// virtual hosts: routes bound to Host header (or absolute-form target),
+// matched before trie lookup
+package router
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/s00inx/goserver/server/engine"
+)
+
+// virtual host entry, name is lowercase;
+// for wildcard (*.example.com) name stores suffix with dot (.example.com)
+type host struct {
+	name     []byte
+	wildcard bool
+	rt       *routes
+}
+
+var (
+	schemeSep = []byte("://")
+)
+
+// get (or create) route trees for host pattern
+func (r *HTTPRouter) hostRoutes(pattern string) *routes {
+	p := strings.ToLower(strings.TrimSuffix(pattern, "."))
+	wildcard := strings.HasPrefix(p, "*.")
+	if wildcard {
+		p = p[1:]
+	}
+
+	for i := range r.hosts {
+		h := &r.hosts[i]
+		if h.wildcard == wildcard && string(h.name) == p {
+			return h.rt
+		}
+	}
+
+	rt := &routes{}
+	rt.init()
+	r.hosts = append(r.hosts, host{name: []byte(p), wildcard: wildcard, rt: rt})
+	return rt
+}
+
+// check if request target is absolute-form (http://host/path)
+func isAbsoluteForm(s *engine.Session) bool {
+	pb := s.Req.Path.AsBuf(s)
+	return len(pb) > 0 && pb[0] != '/' && bytes.Contains(pb, schemeSep)
+}
+
+// pick routes by host: absolute-form target wins over Host header,
+// exact host first, then longest wildcard, default routes if nothing matched
+func (r *HTTPRouter) selectHost(s *engine.Session) *routes {
+	var hb []byte
+	if isAbsoluteForm(s) {
+		hb = cutAbsoluteForm(s)
+	} else {
+		hb = hostHeader(s)
+	}
+	if len(r.hosts) == 0 || len(hb) == 0 {
+		return &r.routes
+	}
+	hb = stripPort(hb)
+
+	var best *host
+	for i := range r.hosts {
+		h := &r.hosts[i]
+		if !h.wildcard {
+			if bytes.EqualFold(h.name, hb) {
+				return h.rt
+			}
+			continue
+		}
+		// *.example.com matches any subdomain, but not example.com itself
+		if len(hb) > len(h.name) && bytes.EqualFold(hb[len(hb)-len(h.name):], h.name) {
+			if best == nil || len(h.name) > len(best.name) {
+				best = h
+			}
+		}
+	}
+	if best != nil {
+		return best.rt
+	}
+	return &r.routes
+}
+
+// cut scheme and authority from absolute-form target so Path starts at path,
+// returns authority (host[:port])
+func cutAbsoluteForm(s *engine.Session) []byte {
+	pb := s.Req.Path.AsBuf(s)
+	i := bytes.Index(pb, schemeSep)
+	st := i + len(schemeSep)
+
+	end := bytes.IndexByte(pb[st:], '/')
+	if end == -1 {
+		end = len(pb) - st
+	}
+	auth := pb[st : st+end]
+
+	// userinfo is not allowed in http(s) uri, but skip it just in case
+	if at := bytes.LastIndexByte(auth, '@'); at != -1 {
+		auth = auth[at+1:]
+	}
+
+	s.Req.Path.St += uint16(st + end)
+	return auth
+}
+
+// find Host header value
+func hostHeader(s *engine.Session) []byte {
+	return bytes.TrimSpace(s.KnownHeader(engine.HHost))
+}
+
+// host[:port] -> host, [v6]:port -> [v6], trailing dot removed
+func stripPort(h []byte) []byte {
+	if len(h) > 0 && h[0] == '[' {
+		if i := bytes.IndexByte(h, ']'); i != -1 {
+			return h[:i+1]
+		}
+		return h
+	}
+	if i := bytes.LastIndexByte(h, ':'); i != -1 {
+		h = h[:i]
+	}
+	return bytes.TrimSuffix(h, []byte{'.'})
+}
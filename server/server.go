@@ -2,9 +2,11 @@ package server
 
 import (
 	"io"
+	"log/slog"
 	"sync"
 
 	"github.com/s00inx/goserver/server/engine"
+	"github.com/s00inx/goserver/server/http2"
 	"github.com/s00inx/goserver/server/protocol"
 	"github.com/s00inx/goserver/server/router"
 )
@@ -12,11 +14,85 @@ import (
 // Используем алиасы типов (Type Aliasing), чтобы main не импортировал router
 type Context = router.Context
 type Handler = router.Handler
+type Logger = engine.Logger
+type ListenerOptions = engine.ListenerOptions
+type Limits = protocol.Limits
+type Stream = router.Stream
+type ChunkedWriter = router.ChunkedWriter
+type ExpectFunc = router.ExpectFunc
+type Cookie = router.Cookie
+type FormPart = router.FormPart
+type FieldError = router.FieldError
+type BindErrors = router.BindErrors
+type Violation = router.Violation
+type ValidationError = router.ValidationError
+type ValidatorFunc = router.ValidatorFunc
+
+const (
+	SameSiteLax    = router.SameSiteLax
+	SameSiteStrict = router.SameSiteStrict
+	SameSiteNone   = router.SameSiteNone
+
+	SlashKeep   = router.SlashKeep
+	SlashDecode = router.SlashDecode
+	SlashReject = router.SlashReject
+)
+
+var (
+	ErrWriterClosed = router.ErrWriterClosed
+	ErrSlowPeer     = engine.ErrSlowPeer
+	ErrBindTarget   = router.ErrBindTarget
+)
+
+// check struct against its validate tags, see router/validate.go
+func Validate(v any) error { return router.Validate(v) }
+
+// add custom rule for validate tags, call it before first use of types with it
+func RegisterValidator(name string, f ValidatorFunc) { router.RegisterValidator(name, f) }
+
+// handler with request bound to T and validated, invalid requests are answered 400/422;
+// bad validate tags of T panic when handler is built
+func Bound[T any](h func(c *Context, v *T)) Handler { return router.Bound(h) }
+
+// adapter for log/slog, pass it to Config.Logger
+func NewSlogLogger(l *slog.Logger) Logger { return engine.NewSlogLogger(l) }
 
 type Server struct {
 	R      *router.HTTPRouter
+	proxy  protocol.ProxyParser
 	parser protocol.HTTPParser
 	engine engine.Engine
+	log    Logger
+	h2c    bool
+}
+
+// server settings, zero value is a plain HTTP server
+type Config struct {
+	// request size limits, exceeding them is answered with 414/431/413 and conn close
+	Limits Limits
+	// accept ambiguous request framing of legacy clients instead of 400 (see protocol.HTTPParser)
+	LenientParsing bool
+
+	// PROXY protocol v1/v2 on listener (ProxyOff, ProxyOptional, ProxyRequired)
+	Proxy protocol.ProxyMode
+	// optional callback for PROXY v2 TLVs
+	ProxyTLV protocol.ProxyTLVFunc
+
+	// listener and accepted conns socket options, nil means engine.DefaultListenerOptions
+	Listener *ListenerOptions
+
+	// structured logger for engine and server events, nil disables logging
+	Logger Logger
+
+	// lock workers and accept loop to OS threads pinned to CPUs (all allowed cpus if empty)
+	PinCPU bool
+	CPUs   []int
+
+	// encoded slash (%2F) in request path: kept as part of segment (default), decoded to separator or rejected
+	EncodedSlash router.SlashPolicy
+
+	// HTTP/2 over cleartext: prior knowledge (client preface) and "Upgrade: h2c"
+	H2C bool
 }
 
 var ctxPool = sync.Pool{
@@ -26,44 +102,139 @@ var ctxPool = sync.Pool{
 }
 
 func New() *Server {
-	return &Server{
+	return NewWithConfig(Config{})
+}
+
+func NewWithConfig(cfg Config) *Server {
+	srv := &Server{
 		R:      router.NewHTTPRouter(),
-		parser: protocol.HTTPParser{},
-		engine: engine.Engine{},
+		proxy:  protocol.ProxyParser{Mode: cfg.Proxy, OnTLV: cfg.ProxyTLV},
+		parser: protocol.HTTPParser{Limits: cfg.Limits, Lenient: cfg.LenientParsing},
+		engine: engine.Engine{Log: cfg.Logger, Listener: cfg.Listener, PinCPU: cfg.PinCPU, CPUs: cfg.CPUs},
+		log:    cfg.Logger,
+		h2c:    cfg.H2C,
 	}
+	srv.R.EncodedSlash = cfg.EncodedSlash
+	return srv
 }
 
 func (srv *Server) Get(path string, h Handler)  { srv.R.Get(path, h) }
 func (srv *Server) Post(path string, h Handler) { srv.R.Post(path, h) }
 func (srv *Server) Use(mw Handler)              { srv.R.Use(mw) }
+
+// decide on Expect: 100-continue requests to route before their body is read
+func (srv *Server) Expect(method, path string, f ExpectFunc) { srv.R.Expect(method, path, f) }
 func (srv *Server) Group(prefix string) *Group {
 	return &Group{rg: srv.R.Group(prefix)}
 }
 
+// routes for virtual host (api.example.com or *.example.com),
+// requests with other hosts go to routes registered without Host
+func (srv *Server) Host(pattern string) *Group {
+	return &Group{rg: srv.R.Host(pattern)}
+}
+
 func (srv *Server) Run(addr [4]byte, port int) error {
+	// one request of http/1 conn or http2 stream
+	serve := func(s *engine.Session) {
+		handlers := srv.R.Serve(s)
+		c := ctxPool.Get().(*router.Context)
+		c.Reset(s, handlers)
+
+		if handlers != nil {
+			c.Next()
+		} else {
+			c.Send404()
+		}
+		c.End()
+		ctxPool.Put(c)
+	}
+
+	srv.parser.Expect = func(s *engine.Session) int {
+		c := ctxPool.Get().(*router.Context)
+		c.Reset(s, nil)
+		code := srv.R.CheckExpect(c)
+		ctxPool.Put(c)
+		return code
+	}
+
 	parseFunc := func(s *engine.Session) (bool, error) {
 		onReq := func(s *engine.Session, buf []byte) {
-			handlers := srv.R.Serve(s)
-			c := ctxPool.Get().(*router.Context)
-			c.Reset(s, handlers)
-
-			if handlers != nil {
-				c.Next()
-			} else {
-				c.Send404()
+			if srv.h2c && http2.Upgrade(s, serve) {
+				return
 			}
-			ctxPool.Put(c)
+			serve(s)
+		}
+
+		// PROXY header goes first, HTTP parser must not see it
+		if ok, err := srv.proxy.Parse(s); !ok || err != nil {
+			if err != nil && srv.enabled(engine.LevelInfo) {
+				srv.log.Log(engine.LevelInfo, "proxy header rejected", engine.Int("fd", int(s.Fd)), engine.Err(err))
+			}
+			return false, err
+		}
+		if s.Offset == 0 {
+			return true, nil
 		}
-		return srv.parser.Parse(s, onReq)
+
+		if srv.h2c {
+			ok, more := http2.IsPreface(s.Buf[:s.Offset])
+			if ok {
+				return http2.Serve(s, serve)
+			}
+			if more {
+				return false, nil
+			}
+		}
+
+		release, err := srv.parser.Parse(s, onReq)
+		if err != nil {
+			if srv.enabled(engine.LevelInfo) {
+				srv.log.Log(engine.LevelInfo, "request rejected",
+					engine.Int("fd", int(s.Fd)), engine.Str("remote", s.Remote.String()), engine.Err(err))
+			}
+			// answer with status before engine closes the conn
+			if code := protocol.StatusCode(err); code != 0 {
+				writeError(s, code)
+			}
+		}
+		return release, err
 	}
 
 	return srv.engine.StartEpoll(addr, port, parseFunc)
 }
 
+var errHeaders = []engine.Header{
+	{Key: []byte("Content-Type"), Val: []byte("text/plain")},
+	{Key: []byte("Connection"), Val: []byte("close")},
+}
+
+// error response for rejected request, conn is closed after it
+func writeError(s *engine.Session, code int) {
+	body := protocol.StatusText(code)
+	engine.WriteV(s, func(dst []byte) int {
+		return protocol.BuildHeader(code, errHeaders, len(body), dst, s.Date())
+	}, body)
+}
+
+// graceful stop, progress goes to Config.Logger or, without logger, to out (nil is os.Stdout)
 func (srv *Server) Stop(out *io.Writer) {
 	srv.engine.StopServer(out)
 }
 
+func (srv *Server) enabled(lvl engine.Level) bool {
+	return srv.log != nil && srv.log.Enabled(lvl)
+}
+
+// closed when server is accepting conns
+func (srv *Server) Ready() <-chan struct{} { return srv.engine.Ready() }
+
+// bound port, useful after Run on port 0
+func (srv *Server) Port() int { return srv.engine.Port() }
+
+// serve already connected socket (socketpair end), server owns fd after that
+func (srv *Server) Attach(fd int) error { return srv.engine.Attach(fd) }
+
 type Group struct {
 	rg *router.RouteGroup
 }
@@ -71,3 +242,8 @@ type Group struct {
 func (g *Group) Get(path string, h Handler)  { g.rg.Get(path, h) }
 func (g *Group) Post(path string, h Handler) { g.rg.Post(path, h) }
 func (g *Group) Group(prefix string) *Group  { return &Group{rg: g.rg.Group(prefix)} }
+func (g *Group) Host(pattern string) *Group  { return &Group{rg: g.rg.Host(pattern)} }
+func (g *Group) Use(mw Handler)              { g.rg.Use(mw) }
+func (g *Group) Expect(method, path string, f ExpectFunc) {
+	g.rg.Expect(method, path, f)
+}
@@ -0,0 +1,435 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/s00inx/goserver/server/engine"
+)
+
+const (
+	// how long Close waits for peer close frame before dropping conn
+	closeTimeout = 5 * time.Second
+	// assembled message buffer bigger than this is not kept between messages
+	keepMsgBuf = 64 << 10
+)
+
+// websocket conn over hijacked session;
+// reading is done by engine worker, writes are safe from any goroutine
+type Conn struct {
+	Data any // free slot for user state
+
+	s      *engine.Session
+	u      *Upgrader
+	remote netip.AddrPort
+	proto  string
+	accept [28]byte
+
+	// guards writes and session: after closed is set session belongs to engine again
+	mu        sync.Mutex
+	closed    bool
+	closeSent bool
+
+	// reader state, touched only by worker of session
+	fin     bool
+	inFrame bool
+	mask    [4]byte
+	mpos    int    // position in mask key for next payload byte
+	remain  uint64 // unread payload of current frame
+	msgOp   Opcode // opcode of fragmented message in progress, 0 if none
+	msg     []byte // fragments assembled so far
+
+	code   int
+	reason string
+}
+
+// protocol violation, conn is closed with code
+type closeError struct {
+	code int
+	msg  string
+}
+
+func (e *closeError) Error() string { return "websocket: " + e.msg }
+
+func protoErr(msg string) error { return &closeError{code: CloseProtocolError, msg: msg} }
+
+// returned to engine after close handshake so it drops the conn
+var errPeerClosed = errors.New("websocket: closed by peer")
+
+// client address
+func (c *Conn) RemoteAddr() netip.AddrPort { return c.remote }
+
+// negotiated subprotocol, empty if none
+func (c *Conn) Subprotocol() string { return c.proto }
+
+// send one frame, fin=false starts or continues fragmented message (next frames use OpContinuation)
+func (c *Conn) WriteFrame(op Opcode, fin bool, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.closeSent {
+		return ErrClosed
+	}
+	return c.write(op, fin, data)
+}
+
+// send text or binary message in single frame
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	return c.WriteFrame(op, true, data)
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: control frame payload is too big")
+	}
+	return c.WriteFrame(OpPing, true, data)
+}
+
+// start close handshake, conn is dropped when peer answers or after closeTimeout
+func (c *Conn) Close(code int, reason string) error {
+	c.mu.Lock()
+	if c.closed || c.closeSent {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	err := c.sendClose(code, reason)
+	c.mu.Unlock()
+
+	time.AfterFunc(closeTimeout, c.drop)
+	return err
+}
+
+// shutdown socket if peer didn't finish close handshake
+func (c *Conn) drop() {
+	c.mu.Lock()
+	if !c.closed {
+		c.s.Shutdown()
+	}
+	c.mu.Unlock()
+}
+
+// mu must be held
+func (c *Conn) write(op Opcode, fin bool, data []byte) error {
+	var hdr [10]byte
+	n := putHeader(hdr[:], op, fin, len(data))
+	_, err := c.s.WriteDirect(hdr[:n], data)
+	return err
+}
+
+// mu must be held
+func (c *Conn) sendClose(code int, reason string) error {
+	c.closeSent = true
+
+	var p [125]byte
+	n := 0
+	if code != 0 && code != CloseNoStatus {
+		binary.BigEndian.PutUint16(p[:], uint16(code))
+		n = 2 + copy(p[2:], reason)
+	}
+	return c.write(OpClose, true, p[:n])
+}
+
+// engine close hook, after it session can't be used
+func (c *Conn) onClose(*engine.Session) {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	if c.u.OnClose != nil {
+		code := c.code
+		if code == 0 {
+			code = CloseAbnormal
+		}
+		c.u.OnClose(c, code, c.reason)
+	}
+}
+
+// read handler of hijacked session: consume all complete frames (and payload parts of big ones)
+func (c *Conn) serve(s *engine.Session) (bool, error) {
+	buf := s.Buf[:s.Offset]
+	pos := 0
+
+	var err error
+	for err == nil && pos < len(buf) {
+		var n int
+		n, err = c.step(buf[pos:])
+		if n == 0 && err == nil {
+			break
+		}
+		pos += n
+	}
+
+	if pos > 0 {
+		copy(s.Buf, s.Buf[pos:s.Offset])
+		s.Offset -= uint32(pos)
+	}
+
+	if err != nil {
+		var ce *closeError
+		if errors.As(err, &ce) {
+			c.fail(ce.code, ce.msg)
+		}
+		return false, err
+	}
+	return s.Offset == 0, nil
+}
+
+// send close with error code, conn is closed by engine right after
+func (c *Conn) fail(code int, reason string) {
+	c.code, c.reason = code, reason
+
+	c.mu.Lock()
+	if !c.closed && !c.closeSent {
+		c.sendClose(code, reason)
+	}
+	c.mu.Unlock()
+}
+
+// consume one frame header or payload chunk from b, 0 means more data is needed
+func (c *Conn) step(b []byte) (int, error) {
+	if c.inFrame {
+		n := int(min(uint64(len(b)), c.remain))
+		chunk := b[:n]
+		c.mpos = unmask(chunk, c.mask, c.mpos)
+		c.msg = append(c.msg, chunk...)
+		c.remain -= uint64(n)
+
+		if c.remain == 0 {
+			return n, c.endFrame()
+		}
+		return n, nil
+	}
+
+	f, hl, err := parseHeader(b)
+	if err != nil || hl == 0 {
+		return 0, err
+	}
+
+	// control frames are small and can come between fragments, handle them as a whole
+	if f.op >= OpClose {
+		if !f.fin {
+			return 0, protoErr("fragmented control frame")
+		}
+		if f.length > 125 {
+			return 0, protoErr("control frame payload is too big")
+		}
+		total := hl + int(f.length)
+		if len(b) < total {
+			return 0, nil
+		}
+		p := b[hl:total]
+		unmask(p, f.mask, 0)
+		return total, c.control(f.op, p)
+	}
+
+	switch f.op {
+	case OpText, OpBinary:
+		if c.msgOp != 0 {
+			return 0, protoErr("new message inside fragmented one")
+		}
+	case OpContinuation:
+		if c.msgOp == 0 {
+			return 0, protoErr("continuation without message")
+		}
+	default:
+		return 0, protoErr("reserved opcode")
+	}
+	if uint64(len(c.msg))+f.length > uint64(c.u.maxMessage()) {
+		return 0, &closeError{code: CloseTooBig, msg: "message is too big"}
+	}
+
+	// whole unfragmented message is in buffer, deliver it without copy
+	if f.fin && f.op != OpContinuation && uint64(len(b)-hl) >= f.length {
+		total := hl + int(f.length)
+		p := b[hl:total]
+		unmask(p, f.mask, 0)
+		return total, c.deliver(f.op, p)
+	}
+
+	if f.op != OpContinuation {
+		c.msgOp = f.op
+	}
+	c.fin, c.mask, c.mpos, c.remain = f.fin, f.mask, 0, f.length
+	if f.length == 0 {
+		return hl, c.endFrame()
+	}
+	c.inFrame = true
+	return hl, nil
+}
+
+// payload of current frame is read, deliver message if it was the last fragment
+func (c *Conn) endFrame() error {
+	c.inFrame = false
+	if !c.fin {
+		return nil
+	}
+
+	err := c.deliver(c.msgOp, c.msg)
+	c.msgOp = 0
+	if cap(c.msg) > keepMsgBuf {
+		c.msg = nil
+	} else {
+		c.msg = c.msg[:0]
+	}
+	return err
+}
+
+func (c *Conn) deliver(op Opcode, p []byte) error {
+	if op == OpText && !utf8.Valid(p) {
+		return &closeError{code: CloseInvalidPayload, msg: "invalid utf-8 in text message"}
+	}
+	if c.u.OnMessage != nil {
+		c.u.OnMessage(c, op, p)
+	}
+	return nil
+}
+
+func (c *Conn) control(op Opcode, p []byte) error {
+	switch op {
+	case OpPing:
+		c.mu.Lock()
+		if !c.closeSent {
+			c.write(OpPong, true, p)
+		}
+		c.mu.Unlock()
+		return nil
+	case OpPong:
+		return nil
+	case OpClose:
+	default:
+		return protoErr("reserved opcode")
+	}
+
+	code := CloseNoStatus
+	switch {
+	case len(p) == 1:
+		return protoErr("bad close payload")
+	case len(p) >= 2:
+		code = int(binary.BigEndian.Uint16(p))
+		if !validCloseCode(code) {
+			return protoErr("bad close code")
+		}
+		if !utf8.Valid(p[2:]) {
+			return &closeError{code: CloseInvalidPayload, msg: "invalid utf-8 in close reason"}
+		}
+	}
+	c.code = code
+	if len(p) > 2 {
+		c.reason = string(p[2:])
+	}
+
+	// echo close if we didn't start handshake, then engine drops conn
+	c.mu.Lock()
+	if !c.closeSent {
+		c.sendClose(code, "")
+	}
+	c.mu.Unlock()
+	return errPeerClosed
+}
+
+// codes that can appear in close frame
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1014:
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// frame header
+type frame struct {
+	op     Opcode
+	fin    bool
+	length uint64
+	mask   [4]byte
+}
+
+// parse client frame header, returns header length or 0 if it is incomplete
+func parseHeader(b []byte) (frame, int, error) {
+	if len(b) < 2 {
+		return frame{}, 0, nil
+	}
+
+	f := frame{fin: b[0]&0x80 != 0, op: Opcode(b[0] & 0x0f)}
+	if b[0]&0x70 != 0 {
+		return f, 0, protoErr("reserved bits are set")
+	}
+	if b[1]&0x80 == 0 {
+		return f, 0, protoErr("client frame is not masked")
+	}
+
+	hl := 2
+	switch l := b[1] & 0x7f; l {
+	case 126:
+		if len(b) < 4 {
+			return f, 0, nil
+		}
+		f.length = uint64(binary.BigEndian.Uint16(b[2:]))
+		hl = 4
+	case 127:
+		if len(b) < 10 {
+			return f, 0, nil
+		}
+		f.length = binary.BigEndian.Uint64(b[2:])
+		if f.length>>63 != 0 {
+			return f, 0, protoErr("bad payload length")
+		}
+		hl = 10
+	default:
+		f.length = uint64(l)
+	}
+
+	if len(b) < hl+4 {
+		return f, 0, nil
+	}
+	copy(f.mask[:], b[hl:hl+4])
+	return f, hl + 4, nil
+}
+
+// server frame header (never masked) into dst of 10 bytes
+func putHeader(dst []byte, op Opcode, fin bool, n int) int {
+	dst[0] = byte(op)
+	if fin {
+		dst[0] |= 0x80
+	}
+
+	switch {
+	case n < 126:
+		dst[1] = byte(n)
+		return 2
+	case n <= 0xffff:
+		dst[1] = 126
+		binary.BigEndian.PutUint16(dst[2:], uint16(n))
+		return 4
+	default:
+		dst[1] = 127
+		binary.BigEndian.PutUint64(dst[2:], uint64(n))
+		return 10
+	}
+}
+
+// xor payload with mask key in place starting from key position pos, returns next position;
+// 8 bytes at a time, key position doesn't change bc 8 is multiple of 4
+func unmask(b []byte, key [4]byte, pos int) int {
+	i := 0
+	if len(b) >= 8 {
+		var k [8]byte
+		for j := range k {
+			k[j] = key[(pos+j)&3]
+		}
+		kw := binary.LittleEndian.Uint64(k[:])
+		for ; i+8 <= len(b); i += 8 {
+			binary.LittleEndian.PutUint64(b[i:], binary.LittleEndian.Uint64(b[i:])^kw)
+		}
+	}
+	for ; i < len(b); i++ {
+		b[i] ^= key[(pos+i)&3]
+	}
+	return (pos + len(b)) & 3
+}
@@ -0,0 +1,192 @@
+// websocket (RFC 6455) on top of epoll engine:
+// handshake is done from router Context, then conn is hijacked and frames are read by engine workers
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+
+	"github.com/s00inx/goserver/server/router"
+)
+
+// frame opcodes
+type Opcode uint8
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // never sent, reported when close frame has no code
+	CloseAbnormal        = 1006 // never sent, reported when conn is lost without close frame
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+)
+
+// default limit for assembled message
+const defaultMaxMessage = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrVersion      = errors.New("websocket: unsupported version")
+	ErrOrigin       = errors.New("websocket: origin not allowed")
+	ErrClosed       = errors.New("websocket: connection closed")
+)
+
+var (
+	hUpgrade    = []byte("Upgrade")
+	hConnection = []byte("Connection")
+	hKey        = []byte("Sec-WebSocket-Key")
+	hVersion    = []byte("Sec-WebSocket-Version")
+	hAccept     = []byte("Sec-WebSocket-Accept")
+	hProtocol   = []byte("Sec-WebSocket-Protocol")
+	vWebsocket  = []byte("websocket")
+	vUpgrade    = []byte("upgrade")
+	v13         = []byte("13")
+)
+
+// upgrade settings and callbacks, one Upgrader is shared by all conns of route;
+// callbacks are called on engine worker goroutine, so they should not block for long
+type Upgrader struct {
+	MaxMessage   int      // max size of (assembled) message, 0 means 1MB
+	Subprotocols []string // supported subprotocols in order of preference
+
+	// check Origin header, nil allows any origin
+	CheckOrigin func(c *router.Context) bool
+
+	// conn is ready, frames can be sent from here or from any goroutine later
+	OnOpen func(c *Conn)
+	// complete text or binary message, data is valid only during call (it can point into session buffer)
+	OnMessage func(c *Conn, op Opcode, data []byte)
+	// conn is closed, code is from peer close frame (or CloseAbnormal if conn was lost)
+	OnClose func(c *Conn, code int, reason string)
+}
+
+func (u *Upgrader) maxMessage() int {
+	if u.MaxMessage > 0 {
+		return u.MaxMessage
+	}
+	return defaultMaxMessage
+}
+
+// do handshake, send 101 and switch conn to websocket;
+// on error response is already sent (400, 403 or 426) and handler should just return
+func (u *Upgrader) Upgrade(c *router.Context) (*Conn, error) {
+	// websocket over http2 streams is not supported
+	if string(c.Method()) != "GET" || c.Session.Responder != nil ||
+		!bytes.EqualFold(c.Header(hUpgrade), vWebsocket) ||
+		!hasToken(c.Header(hConnection), vUpgrade) {
+		c.SendDirect(400, protocol400)
+		return nil, ErrBadHandshake
+	}
+	if !bytes.Equal(c.Header(hVersion), v13) {
+		c.SetHeader(hVersion, v13)
+		c.SendDirect(426, protocol426)
+		return nil, ErrVersion
+	}
+
+	key := c.Header(hKey)
+	var raw [18]byte
+	if len(key) != 24 {
+		c.SendDirect(400, protocol400)
+		return nil, ErrBadHandshake
+	}
+	if n, err := base64.StdEncoding.Decode(raw[:], key); err != nil || n != 16 {
+		c.SendDirect(400, protocol400)
+		return nil, ErrBadHandshake
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(c) {
+		c.SendDirect(403, protocol403)
+		return nil, ErrOrigin
+	}
+
+	s := c.Session
+	conn := &Conn{s: s, u: u, remote: s.Remote}
+	acceptKey(conn.accept[:], key)
+	conn.proto = u.selectProtocol(c.Header(hProtocol))
+
+	c.SetHeader(hUpgrade, vWebsocket)
+	c.SetHeader(hConnection, hUpgrade)
+	c.SetHeader(hAccept, conn.accept[:])
+	if conn.proto != "" {
+		c.SetHeader(hProtocol, router.S2Bytes(conn.proto))
+	}
+	c.SendDirect(101, nil)
+
+	// 101 must reach client before any frame, and frames are written directly
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+
+	s.Hijack(conn.serve)
+	s.SetPersistent(true)
+	s.OnClose(conn.onClose)
+
+	if u.OnOpen != nil {
+		u.OnOpen(conn)
+	}
+	return conn, nil
+}
+
+// handler that upgrades every request of route
+func (u *Upgrader) Handler() router.Handler {
+	return func(c *router.Context) {
+		u.Upgrade(c)
+	}
+}
+
+var (
+	protocol400 = []byte("Bad Request")
+	protocol403 = []byte("Forbidden")
+	protocol426 = []byte("Upgrade Required")
+)
+
+// base64(sha1(key + GUID)) into 28 byte dst
+func acceptKey(dst, key []byte) {
+	h := sha1.New()
+	h.Write(key)
+	h.Write([]byte(acceptGUID))
+	var sum [sha1.Size]byte
+	base64.StdEncoding.Encode(dst, h.Sum(sum[:0]))
+}
+
+// first supported subprotocol offered by client
+func (u *Upgrader) selectProtocol(offer []byte) string {
+	if len(u.Subprotocols) == 0 || len(offer) == 0 {
+		return ""
+	}
+	for _, p := range u.Subprotocols {
+		for tok := range bytes.SplitSeq(offer, []byte{','}) {
+			if string(bytes.TrimSpace(tok)) == p {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// comma separated header value contains token (case-insensitive)
+func hasToken(v, tok []byte) bool {
+	for part := range bytes.SplitSeq(v, []byte{','}) {
+		if bytes.EqualFold(bytes.TrimSpace(part), tok) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	srv "github.com/s00inx/goserver/server"
+	"github.com/s00inx/goserver/server/goservertest"
+)
+
+var handshake = goservertest.Request{
+	Path: "/ws",
+	Header: [][2]string{
+		{"Upgrade", "websocket"},
+		{"Connection", "keep-alive, Upgrade"},
+		{"Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ=="},
+		{"Sec-WebSocket-Version", "13"},
+	},
+}
+
+// echo server, closes are reported to closed chan
+func newEchoServer(t *testing.T, u *Upgrader) (*goservertest.Server, chan int) {
+	closed := make(chan int, 1)
+	u.OnMessage = func(c *Conn, op Opcode, data []byte) {
+		c.WriteMessage(op, data)
+	}
+	u.OnClose = func(c *Conn, code int, reason string) {
+		closed <- code
+	}
+
+	s := srv.New()
+	s.Get("/ws", u.Handler())
+	s.Get("/ping", func(c *srv.Context) {
+		c.SendDirect(200, []byte("pong"))
+	})
+	return goservertest.Start(t, s), closed
+}
+
+// client side frame, always masked
+func clientFrame(op Opcode, fin bool, payload []byte) []byte {
+	b := []byte{byte(op)}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, key[:]...)
+	for i, c := range payload {
+		b = append(b, c^key[i&3])
+	}
+	return b
+}
+
+// read one server frame
+func readFrame(t *testing.T, c *goservertest.Client) (Opcode, bool, []byte) {
+	t.Helper()
+	c.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	r := c.Reader()
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	if h[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var l [2]byte
+		io.ReadFull(r, l[:])
+		n = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		io.ReadFull(r, l[:])
+		n = binary.BigEndian.Uint64(l[:])
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	return Opcode(h[0] & 0x0f), h[0]&0x80 != 0, p
+}
+
+func upgrade(t *testing.T, ts *goservertest.Server) *goservertest.Client {
+	t.Helper()
+	c := ts.Client(t)
+
+	res, err := c.Do(handshake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != 101 {
+		t.Fatalf("expected 101, got %d %q", res.Code, res.Body)
+	}
+	// RFC 6455 sample key
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad accept key %q", got)
+	}
+	return c
+}
+
+func closeCode(p []byte) int {
+	if len(p) < 2 {
+		return CloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(p))
+}
+
+func TestWebSocket_Echo(t *testing.T) {
+	ts, closed := newEchoServer(t, &Upgrader{})
+	c := upgrade(t, ts)
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 16<<10) // bigger than session buffer
+	tests := []struct {
+		name   string
+		frames [][]byte
+		op     Opcode
+		want   []byte
+	}{
+		{"Text", [][]byte{clientFrame(OpText, true, []byte("hello"))}, OpText, []byte("hello")},
+		{"Empty", [][]byte{clientFrame(OpBinary, true, nil)}, OpBinary, []byte{}},
+		{"Fragmented With Ping", [][]byte{
+			clientFrame(OpText, false, []byte("hel")),
+			clientFrame(OpPing, true, []byte("p")),
+			clientFrame(OpContinuation, true, []byte("lo")),
+		}, OpText, []byte("hello")},
+		{"Big", [][]byte{clientFrame(OpBinary, true, big)}, OpBinary, big},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range tt.frames {
+				// byte by byte for small frames to check incremental parsing
+				if len(f) < 32 {
+					for i := range f {
+						c.WriteRaw(f[i : i+1])
+					}
+					continue
+				}
+				c.WriteRaw(f)
+			}
+
+			op, _, p := readFrame(t, c)
+			if op == OpPong {
+				if string(p) != "p" {
+					t.Errorf("bad pong payload %q", p)
+				}
+				op, _, p = readFrame(t, c)
+			}
+			if op != tt.op || !bytes.Equal(p, tt.want) {
+				t.Errorf("expected op %d (%d bytes), got op %d (%d bytes)", tt.op, len(tt.want), op, len(p))
+			}
+		})
+	}
+
+	// close handshake: server echoes code and drops conn
+	c.WriteRaw(clientFrame(OpClose, true, []byte{0x03, 0xe8, 'b', 'y', 'e'}))
+	op, _, p := readFrame(t, c)
+	if op != OpClose || closeCode(p) != CloseNormal {
+		t.Errorf("expected close 1000, got op %d code %d", op, closeCode(p))
+	}
+	if code := <-closed; code != CloseNormal {
+		t.Errorf("OnClose got code %d", code)
+	}
+}
+
+func TestWebSocket_ProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"Unmasked", []byte{0x81, 0x02, 'h', 'i'}, CloseProtocolError},
+		{"Reserved Bits", append([]byte{0xc1}, clientFrame(OpText, true, []byte("hi"))[1:]...), CloseProtocolError},
+		{"Reserved Opcode", clientFrame(0x3, true, nil), CloseProtocolError},
+		{"Fragmented Ping", clientFrame(OpPing, false, nil), CloseProtocolError},
+		{"Lone Continuation", clientFrame(OpContinuation, true, []byte("x")), CloseProtocolError},
+		{"Bad UTF-8", clientFrame(OpText, true, []byte{0xff, 0xfe}), CloseInvalidPayload},
+		{"Bad Close Code", clientFrame(OpClose, true, []byte{0x03, 0xed}), CloseProtocolError},
+		{"Too Big", clientFrame(OpBinary, true, make([]byte, 2048)), CloseTooBig},
+	}
+
+	ts, closed := newEchoServer(t, &Upgrader{MaxMessage: 1024})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := upgrade(t, ts)
+			c.WriteRaw(tt.frame)
+
+			op, _, p := readFrame(t, c)
+			if op != OpClose || closeCode(p) != tt.code {
+				t.Errorf("expected close %d, got op %d code %d", tt.code, op, closeCode(p))
+			}
+			if code := <-closed; code != tt.code {
+				t.Errorf("OnClose got code %d", code)
+			}
+			// conn is dropped after close
+			if _, err := c.Reader().ReadByte(); err != io.EOF {
+				t.Errorf("expected EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebSocket_Handshake(t *testing.T) {
+	ts, _ := newEchoServer(t, &Upgrader{
+		CheckOrigin: func(c *srv.Context) bool {
+			return string(c.Header([]byte("origin"))) != "http://evil"
+		},
+	})
+
+	with := func(k, v string) goservertest.Request {
+		r := handshake
+		r.Header = nil
+		for _, h := range handshake.Header {
+			if h[0] != k {
+				r.Header = append(r.Header, h)
+			}
+		}
+		if v != "" {
+			r.Header = append(r.Header, [2]string{k, v})
+		}
+		return r
+	}
+
+	tests := []struct {
+		name string
+		req  goservertest.Request
+		code int
+	}{
+		{"No Upgrade", with("Upgrade", ""), 400},
+		{"Bad Key", with("Sec-WebSocket-Key", "short"), 400},
+		{"Old Version", with("Sec-WebSocket-Version", "8"), 426},
+		{"Origin", with("Origin", "http://evil"), 403},
+		{"Lowercase Names", goservertest.Request{Path: "/ws", Header: [][2]string{
+			{"upgrade", "WebSocket"},
+			{"connection", "upgrade"},
+			{"sec-websocket-key", "dGhlIHNhbXBsZSBub25jZQ=="},
+			{"sec-websocket-version", "13"},
+		}}, 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ts.Client(t)
+			res, err := c.Do(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, res.Code)
+			}
+			if tt.code == 426 && res.Header.Get("Sec-WebSocket-Version") != "13" {
+				t.Error("426 without supported version")
+			}
+		})
+	}
+
+	// rejected handshake leaves plain http conn
+	c := ts.Client(t)
+	if res, _ := c.Do(with("Upgrade", "")); res.Code != 400 {
+		t.Fatal(res.Code)
+	}
+	if res, err := c.Do(goservertest.Request{Path: "/ping"}); err != nil || string(res.Body) != "pong" {
+		t.Errorf("conn is broken after rejected upgrade: %v", err)
+	}
+}
+
+func TestWebSocket_ServerPush(t *testing.T) {
+	opened := make(chan *Conn, 1)
+	u := &Upgrader{OnOpen: func(c *Conn) { opened <- c }}
+	ts, closed := newEchoServer(t, u)
+
+	// frames sent in same packet as handshake belong to websocket
+	c := ts.Client(t)
+	req := append(handshake.Bytes(), clientFrame(OpText, true, []byte("early"))...)
+	c.WriteRaw(req)
+	if res, err := c.ReadResponse(); err != nil || res.Code != 101 {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	if _, _, p := readFrame(t, c); string(p) != "early" {
+		t.Errorf("expected early, got %q", p)
+	}
+
+	conn := <-opened
+	go func() {
+		for i := range 3 {
+			conn.WriteMessage(OpBinary, []byte{byte(i)})
+		}
+		conn.Close(CloseGoingAway, "bye")
+	}()
+	for i := range 3 {
+		if op, _, p := readFrame(t, c); op != OpBinary || p[0] != byte(i) {
+			t.Errorf("push %d: got op %d %v", i, op, p)
+		}
+	}
+	op, _, p := readFrame(t, c)
+	if op != OpClose || closeCode(p) != CloseGoingAway || string(p[2:]) != "bye" {
+		t.Errorf("expected close 1001 bye, got op %d %q", op, p)
+	}
+	if err := conn.WriteMessage(OpText, []byte("late")); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	// client answers and server drops conn
+	c.WriteRaw(clientFrame(OpClose, true, p))
+	if code := <-closed; code != CloseGoingAway {
+		t.Errorf("OnClose got code %d", code)
+	}
+}
+
+func TestUnmask(t *testing.T) {
+	key := [4]byte{1, 2, 3, 4}
+	src := []byte("the quick brown fox jumps over the lazy dog")
+
+	want := make([]byte, len(src))
+	for i := range src {
+		want[i] = src[i] ^ key[i&3]
+	}
+
+	// split at every position must give same result as one pass
+	for split := range len(src) {
+		b := bytes.Clone(src)
+		pos := unmask(b[:split], key, 0)
+		unmask(b[split:], key, pos)
+		if !bytes.Equal(b, want) {
+			t.Fatalf("split %d: bad unmask", split)
+		}
+	}
+}